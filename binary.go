@@ -0,0 +1,516 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// binaryVersion is the version of the binary encoding.
+//
+// The encoding begins with the version and a type byte followed,
+// for a window, by the window duration. A table of interned keys
+// and a table of bucket layouts shared by the histograms follow.
+// Each interval is encoded as the time in milliseconds delta from
+// the previous interval and the metrics referencing the tables.
+// Integers are encoded as varints and floats as IEEE 754 bits.
+// Integral bucket layouts are delta encoded as varints. Summaries
+// are encoded as the centroid means and counts, and sketches and HDR
+// histograms as the bin indexes delta encoded as varints and the
+// counts.
+const binaryVersion = 1
+
+// Binary encoding types.
+const (
+	binaryWindow   = 'W'
+	binaryInterval = 'I'
+)
+
+// Binary encoding metric kinds.
+const (
+	binaryCounter = iota + 1
+	binaryGauge
+	binaryHistogram
+	binarySummary
+	binarySketch
+	binaryHDR
+)
+
+// Binary encoding bucket layouts.
+const (
+	layoutIntegral = iota
+	layoutFloat
+)
+
+// ErrInvalidBinary is returned when decoding a malformed binary encoding.
+var ErrInvalidBinary = errors.New("metrics: invalid binary encoding")
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (w Window) MarshalBinary() ([]byte, error) {
+	intervals := make([]*Interval, len(w.Intervals))
+	for n := range w.Intervals {
+		intervals[n] = &w.Intervals[n]
+	}
+	b := []byte{binaryVersion, binaryWindow}
+	b = appendVarint(b, int64(w.Duration))
+	return appendIntervals(b, intervals), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (w *Window) UnmarshalBinary(b []byte) error {
+	d := &binaryDecoder{b: b}
+	d.header(binaryWindow)
+	duration := d.varint()
+	intervals := d.intervals()
+	if d.err != nil {
+		return d.err
+	}
+	if duration < math.MinInt32 || duration > math.MaxInt32 {
+		return ErrInvalidBinary
+	}
+	w.Duration = int(duration)
+	w.Intervals = make([]Interval, len(intervals))
+	for n, i := range intervals {
+		w.Intervals[n] = Interval{time: i.time, metrics: i.metrics}
+	}
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (i *Interval) MarshalBinary() ([]byte, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	b := []byte{binaryVersion, binaryInterval}
+	return appendIntervals(b, []*Interval{i}), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (i *Interval) UnmarshalBinary(b []byte) error {
+	d := &binaryDecoder{b: b}
+	d.header(binaryInterval)
+	intervals := d.intervals()
+	if d.err != nil {
+		return d.err
+	}
+	if len(intervals) != 1 {
+		return ErrInvalidBinary
+	}
+	i.time = intervals[0].time
+	i.metrics = intervals[0].metrics
+	return nil
+}
+
+// appendIntervals appends the key and bucket layout tables
+// followed by the intervals to b.
+func appendIntervals(b []byte, intervals []*Interval) []byte {
+	keys := make(map[string]uint64)
+	var keyTable []string
+	for _, i := range intervals {
+		for k := range i.metrics {
+			if _, ok := keys[k]; !ok {
+				keys[k] = 0
+				keyTable = append(keyTable, k)
+			}
+		}
+	}
+	sort.Strings(keyTable)
+	for n, k := range keyTable {
+		keys[k] = uint64(n)
+	}
+	b = appendUvarint(b, uint64(len(keyTable)))
+	for _, k := range keyTable {
+		b = appendUvarint(b, uint64(len(k)))
+		b = append(b, k...)
+	}
+	// Layout indexes are offset by one to distinguish nil buckets.
+	layouts := make(map[string]uint64)
+	var layoutTable [][]Bucket
+	for _, i := range intervals {
+		for _, k := range keyTable {
+			h, ok := i.metrics[k].(*Histogram)
+			if !ok || h.Buckets == nil {
+				continue
+			}
+			id := layoutID(h.Buckets)
+			if _, ok := layouts[id]; !ok {
+				layoutTable = append(layoutTable, h.Buckets)
+				layouts[id] = uint64(len(layoutTable))
+			}
+		}
+	}
+	b = appendUvarint(b, uint64(len(layoutTable)))
+	for _, buckets := range layoutTable {
+		b = appendLayout(b, buckets)
+	}
+	b = appendUvarint(b, uint64(len(intervals)))
+	prev := int64(0)
+	for _, i := range intervals {
+		t := i.time.UnixMilli()
+		b = appendVarint(b, t-prev)
+		prev = t
+		n := 0
+		for _, k := range keyTable {
+			if _, ok := i.metrics[k]; ok {
+				n++
+			}
+		}
+		b = appendUvarint(b, uint64(n))
+		for _, k := range keyTable {
+			v, ok := i.metrics[k]
+			if !ok {
+				continue
+			}
+			b = appendUvarint(b, keys[k])
+			switch t := v.(type) {
+			case *Counter:
+				b = append(b, binaryCounter)
+				b = appendFloat(b, t.Min, t.Max, t.Value)
+				b = appendUvarint(b, t.Count)
+			case *Gauge:
+				b = append(b, binaryGauge)
+				b = appendFloat(b, t.Min, t.Max, t.Value)
+				b = appendUvarint(b, t.Count)
+			case *Histogram:
+				b = append(b, binaryHistogram)
+				b = appendFloat(b, t.Min, t.Max, t.Sum)
+				b = appendUvarint(b, t.Count)
+				b = appendUvarint(b, t.Dropped)
+				if t.Buckets == nil {
+					b = appendUvarint(b, 0)
+					continue
+				}
+				b = appendUvarint(b, layouts[layoutID(t.Buckets)])
+				for _, bucket := range t.Buckets {
+					b = appendUvarint(b, bucket.Count)
+				}
+			case *Summary:
+				if len(t.buffer) > 0 {
+					t = copySummary(t)
+				}
+				b = append(b, binarySummary)
+				b = appendFloat(b, t.Min, t.Max, t.Sum, t.Compression)
+				b = appendUvarint(b, t.Count)
+				b = appendUvarint(b, uint64(len(t.Centroids)))
+				for _, c := range t.Centroids {
+					b = appendFloat(b, c.Mean)
+					b = appendUvarint(b, c.Count)
+				}
+			case *Sketch:
+				b = append(b, binarySketch)
+				b = appendFloat(b, t.Min, t.Max, t.Sum, t.Accuracy)
+				b = appendUvarint(b, t.Count)
+				b = appendUvarint(b, t.Zero)
+				b = appendBins(b, t.Positive)
+				b = appendBins(b, t.Negative)
+			case *HDRHistogram:
+				b = append(b, binaryHDR)
+				b = appendVarint(b, t.Min)
+				b = appendVarint(b, t.Max)
+				b = appendFloat(b, t.Sum)
+				b = appendUvarint(b, t.Count)
+				b = appendUvarint(b, uint64(t.Digits))
+				b = appendBins(b, t.Counts)
+			}
+		}
+	}
+	return b
+}
+
+// appendBins appends the number of bins followed by the delta
+// encoded index and the count of each bin in ascending order.
+func appendBins(b []byte, bins map[int]uint64) []byte {
+	b = appendUvarint(b, uint64(len(bins)))
+	prev := 0
+	for _, i := range sortedBins(bins) {
+		b = appendVarint(b, int64(i-prev))
+		b = appendUvarint(b, bins[i])
+		prev = i
+	}
+	return b
+}
+
+// layoutID returns a key identifying the bucket values.
+func layoutID(buckets []Bucket) string {
+	b := make([]byte, 0, len(buckets)*8)
+	for _, bucket := range buckets {
+		b = appendUint64(b, math.Float64bits(bucket.Value))
+	}
+	return string(b)
+}
+
+// appendLayout appends the bucket values to b. Integral values
+// are delta encoded as varints, otherwise the bits are appended.
+func appendLayout(b []byte, buckets []Bucket) []byte {
+	b = appendUvarint(b, uint64(len(buckets)))
+	for _, bucket := range buckets {
+		v := bucket.Value
+		if v != math.Trunc(v) || math.Abs(v) > 1<<53 || (v == 0 && math.Signbit(v)) {
+			b = append(b, layoutFloat)
+			return appendFloat(b, bucketValues(buckets)...)
+		}
+	}
+	b = append(b, layoutIntegral)
+	prev := int64(0)
+	for _, bucket := range buckets {
+		v := int64(bucket.Value)
+		b = appendVarint(b, v-prev)
+		prev = v
+	}
+	return b
+}
+
+// appendUvarint appends the unsigned varint encoding of v to b.
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// appendVarint appends the signed varint encoding of v to b.
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// appendUint64 appends the little endian encoding of v to b.
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// bucketValues returns the values of the buckets.
+func bucketValues(buckets []Bucket) []float64 {
+	values := make([]float64, len(buckets))
+	for i, b := range buckets {
+		values[i] = b.Value
+	}
+	return values
+}
+
+// appendFloat appends the bits of each value to b.
+func appendFloat(b []byte, values ...float64) []byte {
+	for _, v := range values {
+		b = appendUint64(b, math.Float64bits(v))
+	}
+	return b
+}
+
+// binaryDecoder decodes the binary encoding. The first
+// error encountered is retained and subsequent reads
+// return zero values.
+type binaryDecoder struct {
+	b   []byte
+	err error
+}
+
+// fail records the invalid encoding error.
+func (d *binaryDecoder) fail() {
+	if d.err == nil {
+		d.err = ErrInvalidBinary
+	}
+	d.b = nil
+}
+
+// header decodes the version and type.
+func (d *binaryDecoder) header(typ byte) {
+	if len(d.b) < 2 || d.b[0] != binaryVersion || d.b[1] != typ {
+		d.fail()
+		return
+	}
+	d.b = d.b[2:]
+}
+
+// byte decodes a single byte.
+func (d *binaryDecoder) byte() byte {
+	if len(d.b) < 1 {
+		d.fail()
+		return 0
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c
+}
+
+// uvarint decodes an unsigned varint.
+func (d *binaryDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// varint decodes a signed varint.
+func (d *binaryDecoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// float decodes the bits of a float.
+func (d *binaryDecoder) float() float64 {
+	if len(d.b) < 8 {
+		d.fail()
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.b))
+	d.b = d.b[8:]
+	return v
+}
+
+// length decodes a length of elements that are each encoded in
+// at least size bytes, failing if the remaining input is too short.
+func (d *binaryDecoder) length(size int) int {
+	n := d.uvarint()
+	if n > uint64(len(d.b)/size) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+// bins decodes the bins of a sketch or HDR histogram, or
+// returns nil if there are no bins.
+func (d *binaryDecoder) bins() map[int]uint64 {
+	n := d.length(2)
+	if n == 0 {
+		return nil
+	}
+	bins := make(map[int]uint64, n)
+	i := int64(0)
+	for c := 0; c < n; c++ {
+		i += d.varint()
+		bins[int(i)] = d.uvarint()
+	}
+	return bins
+}
+
+// intervals decodes the key and bucket layout
+// tables followed by the intervals.
+func (d *binaryDecoder) intervals() []*Interval {
+	keys := make([]string, d.length(1))
+	for n := range keys {
+		k := string(d.b[:d.length(1)])
+		d.b = d.b[len(k):]
+		if !utf8.ValidString(k) || validateStoredKey(k) != nil {
+			d.fail()
+		}
+		keys[n] = k
+	}
+	layouts := make([][]float64, d.length(2))
+	for n := range layouts {
+		values := make([]float64, d.length(1))
+		switch d.byte() {
+		case layoutIntegral:
+			prev := int64(0)
+			for i := range values {
+				prev += d.varint()
+				values[i] = float64(prev)
+			}
+		case layoutFloat:
+			for i := range values {
+				values[i] = d.float()
+			}
+		default:
+			d.fail()
+		}
+		layouts[n] = values
+	}
+	intervals := make([]*Interval, d.length(2))
+	t := int64(0)
+	for n := range intervals {
+		t += d.varint()
+		i := newInterval(time.UnixMilli(t))
+		count := d.length(2)
+		for c := 0; c < count && d.err == nil; c++ {
+			index := d.uvarint()
+			if index >= uint64(len(keys)) {
+				d.fail()
+				break
+			}
+			k := keys[index]
+			_, kind := splitKind(k)
+			if _, ok := i.metrics[k]; ok {
+				d.fail()
+				break
+			}
+			switch d.byte() {
+			case binaryCounter:
+				if kind != kindCounter {
+					d.fail()
+				}
+				i.metrics[k] = &Counter{Min: d.float(), Max: d.float(), Value: d.float(), Count: d.uvarint()}
+			case binaryGauge:
+				if kind != kindGauge {
+					d.fail()
+				}
+				i.metrics[k] = &Gauge{Min: d.float(), Max: d.float(), Value: d.float(), Count: d.uvarint()}
+			case binaryHistogram:
+				if kind != kindHistogram {
+					d.fail()
+				}
+				h := &Histogram{Min: d.float(), Max: d.float(), Sum: d.float(), Count: d.uvarint(), Dropped: d.uvarint()}
+				layout := d.uvarint()
+				if layout > uint64(len(layouts)) {
+					d.fail()
+					break
+				}
+				if layout > 0 {
+					values := layouts[layout-1]
+					if len(values) > len(d.b) {
+						d.fail()
+						break
+					}
+					h.Buckets = make([]Bucket, len(values))
+					for b, v := range values {
+						h.Buckets[b] = Bucket{Value: v, Count: d.uvarint()}
+					}
+				}
+				i.metrics[k] = h
+			case binarySummary:
+				if kind != kindSummary {
+					d.fail()
+				}
+				m := &Summary{Min: d.float(), Max: d.float(), Sum: d.float(), Compression: d.float(), Count: d.uvarint()}
+				m.Centroids = make([]Centroid, d.length(9))
+				for c := range m.Centroids {
+					m.Centroids[c] = Centroid{Mean: d.float(), Count: d.uvarint()}
+				}
+				i.metrics[k] = m
+			case binarySketch:
+				if kind != kindSketch {
+					d.fail()
+				}
+				m := &Sketch{Min: d.float(), Max: d.float(), Sum: d.float(), Accuracy: d.float(), Count: d.uvarint(), Zero: d.uvarint()}
+				m.Positive = d.bins()
+				m.Negative = d.bins()
+				i.metrics[k] = m
+			case binaryHDR:
+				if kind != kindHDR {
+					d.fail()
+				}
+				m := &HDRHistogram{Min: d.varint(), Max: d.varint(), Sum: d.float(), Count: d.uvarint(), Digits: int(d.uvarint())}
+				m.Counts = d.bins()
+				i.metrics[k] = m
+			default:
+				d.fail()
+			}
+		}
+		intervals[n] = i
+	}
+	if len(d.b) > 0 {
+		d.fail()
+	}
+	return intervals
+}
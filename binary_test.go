@@ -0,0 +1,131 @@
+package metrics_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/pnelson/metrics"
+)
+
+func newTestWindow() metrics.Window {
+	m, clock := newTestMetrics()
+	m.Buckets([]string{"exp"}, metrics.NewExponentialBuckets(1, 1.5, 10))
+	for n := 0; n < 3; n++ {
+		if n > 0 {
+			clock.Advance(testInterval)
+			m.Tick()
+		}
+		m.Add([]string{"c"}, float64(n)+0.5)
+		m.Set([]string{"g"}, float64(-n), metrics.Label{Name: "a", Value: "b"})
+		m.Put([]string{"latency"}, float64(n*100))
+		m.Put([]string{"exp"}, float64(n))
+		m.Observe([]string{"summary"}, float64(n)/3)
+		m.Sample([]string{"sketch"}, float64(n-1)*1000)
+		m.Record([]string{"hdr"}, int64(n*n)*1000)
+	}
+	return m.Window()
+}
+
+func TestWindowBinary(t *testing.T) {
+	want := newTestWindow()
+	b, err := want.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	j, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(b) >= len(j)/3 {
+		t.Fatalf("should be compact\nbinary %d bytes\njson %d bytes", len(b), len(j))
+	}
+	have := metrics.Window{}
+	err = have.UnmarshalBinary(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("binary\nhave %v\nwant %v", have, want)
+	}
+	for n := len(b) - 1; n >= 0; n-- {
+		err = have.UnmarshalBinary(b[:n])
+		if err != metrics.ErrInvalidBinary {
+			t.Fatalf("should error on truncated input at %d\nhave %v", n, err)
+		}
+	}
+}
+
+func TestIntervalBinary(t *testing.T) {
+	w := newTestWindow()
+	want := &w.Intervals[len(w.Intervals)-1]
+	b, err := want.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	have := &metrics.Interval{}
+	err = have.UnmarshalBinary(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("binary\nhave %v\nwant %v", have, want)
+	}
+	err = (&metrics.Window{}).UnmarshalBinary(b)
+	if err != metrics.ErrInvalidBinary {
+		t.Fatalf("should error on mismatched type\nhave %v", err)
+	}
+}
+
+func FuzzWindowBinary(f *testing.F) {
+	w := newTestWindow()
+	b, err := w.MarshalBinary()
+	if err != nil {
+		f.Fatalf("unexpected error: %v", err)
+	}
+	f.Add(b)
+	b, err = (metrics.Window{}).MarshalBinary()
+	if err != nil {
+		f.Fatalf("unexpected error: %v", err)
+	}
+	f.Add(b)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		w := metrics.Window{}
+		err := w.UnmarshalBinary(b)
+		if err != nil {
+			return
+		}
+		j, err := json.Marshal(w)
+		if err != nil {
+			return // json does not support NaN or infinity
+		}
+		b2, err := w.MarshalBinary()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		have := metrics.Window{}
+		err = have.UnmarshalBinary(b2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(have, w) {
+			t.Fatalf("binary\nhave %v\nwant %v", have, w)
+		}
+		want := metrics.Window{}
+		err = json.Unmarshal(j, &want)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Fatalf("json\nhave %v\nwant %v", have, want)
+		}
+		j2, err := json.Marshal(have)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(j2, j) {
+			t.Fatalf("json\nhave %s\nwant %s", j2, j)
+		}
+	})
+}
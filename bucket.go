@@ -1,8 +1,10 @@
 package metrics
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
+	"strconv"
 )
 
 // Bucket is a count of samples that fall between the
@@ -18,6 +20,21 @@ func (b Bucket) MarshalJSON() ([]byte, error) {
 	return []byte(fmt.Sprintf("[%v,%d]", b.Value, b.Count)), nil
 }
 
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (b *Bucket) UnmarshalJSON(data []byte) error {
+	var v [2]json.Number
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	b.Value, err = v[0].Float64()
+	if err != nil {
+		return err
+	}
+	b.Count, err = strconv.ParseUint(v[1].String(), 10, 64)
+	return err
+}
+
 // defaultLatencyBucketValues represents the default bucket
 // values for measuring response time latency.
 var defaultLatencyBucketValues = []float64{1, 3, 5, 7, 10, 15, 20, 25, 30, 35, 40, 45, 50, 60, 70, 80, 90, 100, 125, 150, 175, 200, 225, 250, 275, 300, 350, 400, 450, 500, 600, 700, 800, 900, 1000, 1250, 1500, 1750, 2000, 2250, 2500, 2750, 3000, 4000, 5000, 6000, 7000, 8000, 9000, 10000}
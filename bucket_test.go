@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
 )
@@ -55,3 +56,19 @@ func TestNewExponentialBuckets(t *testing.T) {
 		}
 	}
 }
+
+func TestBucketJSON(t *testing.T) {
+	want := []Bucket{{1, 0}, {2.5, 3}, {1e+21, 18446744073709551615}}
+	b, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var have []Bucket
+	err = json.Unmarshal(b, &have)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("json\nhave %v\nwant %v", have, want)
+	}
+}
@@ -0,0 +1,519 @@
+package metrics
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// maxShards is the maximum number of shards of a histogram cell.
+const maxShards = 64
+
+// cell represents a metric within the current interval that is
+// recorded by concurrent writers without locking the interval.
+// Cells are replaced with plain metrics once the interval is no
+// longer written to.
+type cell interface {
+	// metric returns a copy of the recorded values as a *Counter,
+	// *Gauge, *Histogram, *Summary, *Sketch or *HDRHistogram.
+	metric() any
+}
+
+// histogramShards returns the number of shards of the histogram
+// cells, the number of CPUs rounded up to a power of two.
+func histogramShards() int {
+	n := 1
+	for n < runtime.GOMAXPROCS(0) && n < maxShards {
+		n <<= 1
+	}
+	return n
+}
+
+// counterCell represents a counter recorded with atomic operations.
+// The values are stored as the bits of the floats. The minimum and
+// maximum are infinite until the first value is recorded.
+type counterCell struct {
+	min   uint64
+	max   uint64
+	value uint64
+	count uint64
+}
+
+// newCounterCell returns a new empty counter cell.
+func newCounterCell() *counterCell {
+	return &counterCell{
+		min: math.Float64bits(math.Inf(1)),
+		max: math.Float64bits(math.Inf(-1)),
+	}
+}
+
+// add adds the value.
+func (c *counterCell) add(value float64) {
+	storeMin(&c.min, value)
+	storeMax(&c.max, value)
+	addFloat(&c.value, value)
+	atomic.AddUint64(&c.count, 1)
+}
+
+// merge merges the counter values of o into the cell.
+func (c *counterCell) merge(o Counter) {
+	if o.Count == 0 {
+		return
+	}
+	storeMin(&c.min, o.Min)
+	storeMax(&c.max, o.Max)
+	addFloat(&c.value, o.Value)
+	atomic.AddUint64(&c.count, o.Count)
+}
+
+// metric implements the cell interface.
+func (c *counterCell) metric() any {
+	count := atomic.LoadUint64(&c.count)
+	if count == 0 {
+		return new(Counter)
+	}
+	return &Counter{
+		Min:   loadFloat(&c.min),
+		Max:   loadFloat(&c.max),
+		Value: loadFloat(&c.value),
+		Count: count,
+	}
+}
+
+// gaugeCell represents a gauge recorded with atomic operations.
+// The values are stored as the bits of the floats. The minimum and
+// maximum are infinite until the first value is recorded.
+type gaugeCell struct {
+	min   uint64
+	max   uint64
+	value uint64
+	count uint64
+}
+
+// newGaugeCell returns a new empty gauge cell.
+func newGaugeCell() *gaugeCell {
+	return &gaugeCell{
+		min: math.Float64bits(math.Inf(1)),
+		max: math.Float64bits(math.Inf(-1)),
+	}
+}
+
+// set sets the gauge value.
+func (g *gaugeCell) set(value float64) {
+	atomic.StoreUint64(&g.value, math.Float64bits(value))
+	storeMin(&g.min, value)
+	storeMax(&g.max, value)
+	atomic.AddUint64(&g.count, 1)
+}
+
+// mod modifies the gauge value by relative value.
+func (g *gaugeCell) mod(value float64) {
+	v := addFloat(&g.value, value)
+	storeMin(&g.min, v)
+	storeMax(&g.max, v)
+	atomic.AddUint64(&g.count, 1)
+}
+
+// merge merges the gauge values of o into the cell. The value
+// of o is expected to have been set after the cell.
+func (g *gaugeCell) merge(o Gauge) {
+	if o.Count == 0 {
+		return
+	}
+	atomic.StoreUint64(&g.value, math.Float64bits(o.Value))
+	storeMin(&g.min, o.Min)
+	storeMax(&g.max, o.Max)
+	atomic.AddUint64(&g.count, o.Count)
+}
+
+// metric implements the cell interface.
+func (g *gaugeCell) metric() any {
+	count := atomic.LoadUint64(&g.count)
+	if count == 0 {
+		return new(Gauge)
+	}
+	return &Gauge{
+		Min:   loadFloat(&g.min),
+		Max:   loadFloat(&g.max),
+		Value: loadFloat(&g.value),
+		Count: count,
+	}
+}
+
+// histogramCell represents a histogram striped across shards that
+// are merged when read. Writers lock the first shard available,
+// starting from a shard chosen by the sample value, so concurrent
+// writers spread across the shards as they contend.
+type histogramCell struct {
+	buckets []Bucket // bucket values with zero counts
+	shards  []histogramShard
+}
+
+// histogramShard represents a shard of a histogram cell.
+// The histogram is created when first written.
+type histogramShard struct {
+	mu sync.Mutex
+	h  *Histogram
+	_  [48]byte // pad to a cache line
+}
+
+// newHistogramCell returns a new empty histogram cell with
+// the bucket values of layout and n shards, a power of two.
+func newHistogramCell(layout []Bucket, n int) *histogramCell {
+	if n < 1 {
+		n = 1
+	}
+	c := &histogramCell{
+		buckets: make([]Bucket, len(layout)),
+		shards:  make([]histogramShard, n),
+	}
+	for i, b := range layout {
+		c.buckets[i] = Bucket{Value: b.Value}
+	}
+	return c
+}
+
+// histogram returns the histogram of the shard,
+// creating it if required. The caller must hold s.mu.
+func (s *histogramShard) histogram(buckets []Bucket) *Histogram {
+	if s.h == nil {
+		v := make([]Bucket, len(buckets))
+		copy(v, buckets)
+		s.h = NewHistogram(v)
+	}
+	return s.h
+}
+
+// put adds value as a sample.
+func (c *histogramCell) put(value float64) {
+	c.putN(value, 1)
+}
+
+// putN adds value as n samples.
+func (c *histogramCell) putN(value float64, n uint64) {
+	mask := len(c.shards) - 1
+	start := shardStart(value, mask)
+	for i := 0; i <= mask; i++ {
+		s := &c.shards[(start+i)&mask]
+		if s.mu.TryLock() {
+			s.histogram(c.buckets).putN(value, n)
+			s.mu.Unlock()
+			return
+		}
+	}
+	s := &c.shards[start]
+	s.mu.Lock()
+	s.histogram(c.buckets).putN(value, n)
+	s.mu.Unlock()
+}
+
+// shardStart returns the shard to start probing
+// from for the value, given the mask of the shards.
+func shardStart(value float64, mask int) int {
+	return int((math.Float64bits(value)*0x9e3779b97f4a7c15)>>32) & mask
+}
+
+// merge merges the samples of o into the cell.
+// ErrBucketMismatch is returned if the bucket values differ.
+func (c *histogramCell) merge(o Histogram) error {
+	s := &c.shards[0]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.histogram(c.buckets).Merge(o)
+}
+
+// metric implements the cell interface.
+func (c *histogramCell) metric() any {
+	buckets := make([]Bucket, len(c.buckets))
+	copy(buckets, c.buckets)
+	h := NewHistogram(buckets)
+	for n := range c.shards {
+		s := &c.shards[n]
+		s.mu.Lock()
+		if s.h != nil {
+			// Shards share the bucket values.
+			_ = h.Merge(*s.h)
+		}
+		s.mu.Unlock()
+	}
+	return h
+}
+
+// summaryCell represents a summary striped across shards
+// that are merged when read, as for histogram cells.
+type summaryCell struct {
+	compression float64
+	shards      []summaryShard
+}
+
+// summaryShard represents a shard of a summary cell.
+// The summary is created when first written.
+type summaryShard struct {
+	mu sync.Mutex
+	s  *Summary
+	_  [48]byte // pad to a cache line
+}
+
+// newSummaryCell returns a new empty summary cell with
+// the compression and n shards, a power of two.
+func newSummaryCell(compression float64, n int) *summaryCell {
+	if n < 1 {
+		n = 1
+	}
+	return &summaryCell{
+		compression: compression,
+		shards:      make([]summaryShard, n),
+	}
+}
+
+// summary returns the summary of the shard,
+// creating it if required. The caller must hold s.mu.
+func (s *summaryShard) summary(compression float64) *Summary {
+	if s.s == nil {
+		s.s = NewSummary(compression)
+	}
+	return s.s
+}
+
+// put adds value as a sample.
+func (c *summaryCell) put(value float64) {
+	mask := len(c.shards) - 1
+	start := shardStart(value, mask)
+	for n := 0; n <= mask; n++ {
+		s := &c.shards[(start+n)&mask]
+		if s.mu.TryLock() {
+			s.summary(c.compression).Put(value)
+			s.mu.Unlock()
+			return
+		}
+	}
+	s := &c.shards[start]
+	s.mu.Lock()
+	s.summary(c.compression).Put(value)
+	s.mu.Unlock()
+}
+
+// merge merges the samples of o into the cell.
+func (c *summaryCell) merge(o Summary) {
+	s := &c.shards[0]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary(c.compression).Merge(o)
+}
+
+// metric implements the cell interface.
+func (c *summaryCell) metric() any {
+	m := NewSummary(c.compression)
+	for n := range c.shards {
+		s := &c.shards[n]
+		s.mu.Lock()
+		if s.s != nil {
+			m.Merge(*s.s)
+		}
+		s.mu.Unlock()
+	}
+	return m
+}
+
+// sketchCell represents a sketch striped across shards
+// that are merged when read, as for histogram cells.
+type sketchCell struct {
+	accuracy float64
+	shards   []sketchShard
+}
+
+// sketchShard represents a shard of a sketch cell.
+// The sketch is created when first written.
+type sketchShard struct {
+	mu sync.Mutex
+	s  *Sketch
+	_  [48]byte // pad to a cache line
+}
+
+// newSketchCell returns a new empty sketch cell with
+// the accuracy and n shards, a power of two.
+func newSketchCell(accuracy float64, n int) *sketchCell {
+	if n < 1 {
+		n = 1
+	}
+	return &sketchCell{
+		accuracy: accuracy,
+		shards:   make([]sketchShard, n),
+	}
+}
+
+// sketch returns the sketch of the shard,
+// creating it if required. The caller must hold s.mu.
+func (s *sketchShard) sketch(accuracy float64) *Sketch {
+	if s.s == nil {
+		s.s = NewSketch(accuracy)
+	}
+	return s.s
+}
+
+// put adds value as a sample.
+func (c *sketchCell) put(value float64) {
+	mask := len(c.shards) - 1
+	start := shardStart(value, mask)
+	for n := 0; n <= mask; n++ {
+		s := &c.shards[(start+n)&mask]
+		if s.mu.TryLock() {
+			s.sketch(c.accuracy).Put(value)
+			s.mu.Unlock()
+			return
+		}
+	}
+	s := &c.shards[start]
+	s.mu.Lock()
+	s.sketch(c.accuracy).Put(value)
+	s.mu.Unlock()
+}
+
+// merge merges the samples of o into the cell.
+// ErrAccuracyMismatch is returned if the accuracy differs.
+func (c *sketchCell) merge(o Sketch) error {
+	s := &c.shards[0]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sketch(c.accuracy).Merge(o)
+}
+
+// metric implements the cell interface.
+func (c *sketchCell) metric() any {
+	m := NewSketch(c.accuracy)
+	for n := range c.shards {
+		s := &c.shards[n]
+		s.mu.Lock()
+		if s.s != nil {
+			// Shards share the accuracy.
+			_ = m.Merge(*s.s)
+		}
+		s.mu.Unlock()
+	}
+	return m
+}
+
+// hdrCell represents an HDR histogram striped across
+// shards that are merged when read, as for histogram cells.
+type hdrCell struct {
+	digits int
+	shards []hdrShard
+}
+
+// hdrShard represents a shard of an HDR histogram cell.
+// The histogram is created when first written.
+type hdrShard struct {
+	mu sync.Mutex
+	h  *HDRHistogram
+	_  [48]byte // pad to a cache line
+}
+
+// newHDRCell returns a new empty HDR histogram cell with
+// the significant digits and n shards, a power of two.
+func newHDRCell(digits, n int) *hdrCell {
+	if n < 1 {
+		n = 1
+	}
+	return &hdrCell{
+		digits: digits,
+		shards: make([]hdrShard, n),
+	}
+}
+
+// histogram returns the HDR histogram of the shard,
+// creating it if required. The caller must hold s.mu.
+func (s *hdrShard) histogram(digits int) *HDRHistogram {
+	if s.h == nil {
+		s.h = NewHDRHistogram(digits)
+	}
+	return s.h
+}
+
+// put adds value as a sample corrected for coordinated
+// omission at the expected interval if positive.
+func (c *hdrCell) put(value, expectedInterval int64) {
+	mask := len(c.shards) - 1
+	start := shardStart(float64(value), mask)
+	for n := 0; n <= mask; n++ {
+		s := &c.shards[(start+n)&mask]
+		if s.mu.TryLock() {
+			s.histogram(c.digits).PutCorrected(value, expectedInterval)
+			s.mu.Unlock()
+			return
+		}
+	}
+	s := &c.shards[start]
+	s.mu.Lock()
+	s.histogram(c.digits).PutCorrected(value, expectedInterval)
+	s.mu.Unlock()
+}
+
+// merge merges the samples of o into the cell.
+// ErrDigitsMismatch is returned if the digits differ.
+func (c *hdrCell) merge(o HDRHistogram) error {
+	s := &c.shards[0]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.histogram(c.digits).Merge(o)
+}
+
+// metric implements the cell interface.
+func (c *hdrCell) metric() any {
+	m := NewHDRHistogram(c.digits)
+	for n := range c.shards {
+		s := &c.shards[n]
+		s.mu.Lock()
+		if s.h != nil {
+			// Shards share the digits.
+			_ = m.Merge(*s.h)
+		}
+		s.mu.Unlock()
+	}
+	return m
+}
+
+// loadFloat atomically loads the float stored as bits at addr.
+func loadFloat(addr *uint64) float64 {
+	return math.Float64frombits(atomic.LoadUint64(addr))
+}
+
+// addFloat atomically adds delta to the float stored
+// as bits at addr and returns the new value.
+func addFloat(addr *uint64, delta float64) float64 {
+	for {
+		old := atomic.LoadUint64(addr)
+		v := math.Float64frombits(old) + delta
+		if atomic.CompareAndSwapUint64(addr, old, math.Float64bits(v)) {
+			return v
+		}
+	}
+}
+
+// storeMin atomically stores value at addr if it
+// is less than the float stored as bits at addr.
+func storeMin(addr *uint64, value float64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if !(value < math.Float64frombits(old)) {
+			return
+		}
+		if atomic.CompareAndSwapUint64(addr, old, math.Float64bits(value)) {
+			return
+		}
+	}
+}
+
+// storeMax atomically stores value at addr if it is
+// greater than the float stored as bits at addr.
+func storeMax(addr *uint64, value float64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if !(value > math.Float64frombits(old)) {
+			return
+		}
+		if atomic.CompareAndSwapUint64(addr, old, math.Float64bits(value)) {
+			return
+		}
+	}
+}
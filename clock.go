@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock represents a source of the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock implements Clock using the system time.
+type realClock struct{}
+
+// Now implements the Clock interface.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// ManualClock implements a Clock that only advances when told to.
+// Use a manual clock with Tick to advance intervals deterministically
+// in tests and simulations.
+type ManualClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+// NewManualClock returns a new manual clock initialized at t.
+func NewManualClock(t time.Time) *ManualClock {
+	return &ManualClock{t: t}
+}
+
+// Now implements the Clock interface.
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Advance advances the clock by d.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// Set sets the clock to t.
+func (c *ManualClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.t = t
+	c.mu.Unlock()
+}
@@ -32,3 +32,33 @@ func (m *Counter) Add(value float64) {
 	m.Value += value
 	m.Count++
 }
+
+// Merge merges the counter values of o into the counter.
+func (m *Counter) Merge(o Counter) {
+	if o.Count == 0 {
+		return
+	}
+	if m.Count == 0 {
+		*m = o
+		return
+	}
+	if o.Min < m.Min {
+		m.Min = o.Min
+	}
+	if o.Max > m.Max {
+		m.Max = o.Max
+	}
+	m.Value += o.Value
+	m.Count += o.Count
+}
+
+// storedCounter returns the stored counter v counted as at least one
+// write. Counters created at zero by NewCounter have a zero count but
+// are merged by presence.
+func storedCounter(v any) Counter {
+	c := *v.(*Counter)
+	if c.Count == 0 {
+		c.Count = 1
+	}
+	return c
+}
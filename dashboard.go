@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	_ "embed"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// DashboardHandler returns an http.Handler that serves a
+// self-contained HTML page rendering every key in the window at
+// the finest resolution. Counters are drawn as bar sparklines,
+// gauges as minimum and maximum bands with the last value, and
+// histograms, summaries, sketches and HDR histograms as 50th, 90th
+// and 99th percentile lines. The page polls the handler with the
+// data query parameter for the sparkline data as JSON.
+func DashboardHandler(m *Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["data"]; ok {
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(newDashboard(m.interval, m.Window()))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+	})
+}
+
+// dashboard represents the sparkline data of the dashboard.
+type dashboard struct {
+	Interval int64             `json:"interval"` // milliseconds
+	Times    []int64           `json:"times"`    // milliseconds
+	Series   []dashboardSeries `json:"series"`
+}
+
+// dashboardSeries represents the sparkline lines of a key. The
+// lines hold a value for each interval, or nil if the key was not
+// recorded within the interval. Counters have a value line, gauges
+// have min, max and last lines, and histograms, summaries, sketches
+// and HDR histograms have p50, p90 and p99 lines.
+type dashboardSeries struct {
+	Key   string                `json:"key"`
+	Kind  string                `json:"kind"`
+	Lines map[string][]*float64 `json:"lines"`
+}
+
+// dashboardPercentiles are the percentile lines of
+// histograms, summaries, sketches and HDR histograms.
+var dashboardPercentiles = []struct {
+	line string
+	p    float64
+}{
+	{"p50", 0.50},
+	{"p90", 0.90},
+	{"p99", 0.99},
+}
+
+// newDashboard returns the sparkline data of the window
+// of intervals of duration d.
+func newDashboard(d time.Duration, w Window) dashboard {
+	db := dashboard{
+		Interval: d.Milliseconds(),
+		Times:    make([]int64, len(w.Intervals)),
+		Series:   []dashboardSeries{},
+	}
+	index := make(map[string]int)
+	for n := range w.Intervals {
+		i := &w.Intervals[n]
+		db.Times[n] = i.time.UnixMilli()
+		for k, v := range i.metrics {
+			x, ok := index[k]
+			if !ok {
+				_, kind := splitKind(k)
+				x = len(db.Series)
+				index[k] = x
+				db.Series = append(db.Series, dashboardSeries{
+					Key:   k,
+					Kind:  strings.TrimPrefix(kind, ":"),
+					Lines: make(map[string][]*float64),
+				})
+			}
+			s := db.Series[x]
+			point := func(line string, value float64) {
+				if math.IsNaN(value) || math.IsInf(value, 0) {
+					return
+				}
+				if s.Lines[line] == nil {
+					s.Lines[line] = make([]*float64, len(w.Intervals))
+				}
+				s.Lines[line][n] = &value
+			}
+			switch t := v.(type) {
+			case *Counter:
+				point("value", t.Value)
+			case *Gauge:
+				point("min", t.Min)
+				point("max", t.Max)
+				point("last", t.Value)
+			case *Histogram:
+				if t.Count == 0 {
+					continue
+				}
+				for _, p := range dashboardPercentiles {
+					point(p.line, t.Percentile(p.p))
+				}
+			case *Summary:
+				if t.Count == 0 {
+					continue
+				}
+				for _, p := range dashboardPercentiles {
+					point(p.line, t.Percentile(p.p))
+				}
+			case *Sketch:
+				if t.Count == 0 {
+					continue
+				}
+				for _, p := range dashboardPercentiles {
+					point(p.line, t.Percentile(p.p))
+				}
+			case *HDRHistogram:
+				if t.Count == 0 {
+					continue
+				}
+				for _, p := range dashboardPercentiles {
+					point(p.line, float64(t.Percentile(p.p)))
+				}
+			}
+		}
+	}
+	sort.Slice(db.Series, func(a, b int) bool {
+		return db.Series[a].Key < db.Series[b].Key
+	})
+	return db
+}
@@ -0,0 +1,106 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/pnelson/metrics"
+)
+
+func TestDashboardHandler(t *testing.T) {
+	m, _ := newTestMetrics()
+	rec := httptest.NewRecorder()
+	metrics.DashboardHandler(m).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("Content-Type\nhave %q\nwant text/html", rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "<script>") {
+		t.Fatalf("should serve the page with an inline script")
+	}
+	if regexp.MustCompile(`(src|href)=["']?(https?:)?//`).MatchString(body) {
+		t.Fatalf("should not load external resources")
+	}
+}
+
+func TestDashboardHandlerData(t *testing.T) {
+	m, clock := newTestMetrics()
+	m.Add([]string{"counter"}, 3)
+	m.Set([]string{"gauge"}, 1)
+	m.Set([]string{"gauge"}, 5)
+	m.Set([]string{"gauge"}, 2)
+	for v := 1; v <= 100; v++ {
+		m.Put([]string{"histogram"}, float64(v))
+	}
+	clock.Advance(testInterval)
+	m.Tick()
+	m.Add([]string{"counter"}, 4)
+	rec := httptest.NewRecorder()
+	metrics.DashboardHandler(m).ServeHTTP(rec, httptest.NewRequest("GET", "/?data", nil))
+	var have struct {
+		Interval int64   `json:"interval"`
+		Times    []int64 `json:"times"`
+		Series   []struct {
+			Key   string                `json:"key"`
+			Kind  string                `json:"kind"`
+			Lines map[string][]*float64 `json:"lines"`
+		} `json:"series"`
+	}
+	err := json.Unmarshal(rec.Body.Bytes(), &have)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if have.Interval != testInterval.Milliseconds() {
+		t.Fatalf("Interval\nhave %d\nwant %d", have.Interval, testInterval.Milliseconds())
+	}
+	if len(have.Times) != 2 || have.Times[0] != testTime.Add(testInterval).UnixMilli() {
+		t.Fatalf("Times\nhave %v\nwant 2 intervals from %d", have.Times, testTime.Add(testInterval).UnixMilli())
+	}
+	if len(have.Series) != 3 {
+		t.Fatalf("Series\nhave %d\nwant %d", len(have.Series), 3)
+	}
+	h := m.Window().Intervals[0].Histogram([]string{"histogram"})
+	tests := []struct {
+		key   string
+		kind  string
+		line  string
+		index int
+		want  float64
+	}{
+		{"/counter:counter", "counter", "value", 0, 3},
+		{"/counter:counter", "counter", "value", 1, 4},
+		{"/gauge:gauge", "gauge", "min", 0, 1},
+		{"/gauge:gauge", "gauge", "max", 0, 5},
+		{"/gauge:gauge", "gauge", "last", 0, 2},
+		{"/histogram:histogram", "histogram", "p50", 0, h.Percentile(0.50)},
+		{"/histogram:histogram", "histogram", "p90", 0, h.Percentile(0.90)},
+		{"/histogram:histogram", "histogram", "p99", 0, h.Percentile(0.99)},
+	}
+	for _, tt := range tests {
+		found := false
+		for _, s := range have.Series {
+			if s.Key != tt.key {
+				continue
+			}
+			found = true
+			if s.Kind != tt.kind {
+				t.Fatalf("%s: kind\nhave %q\nwant %q", tt.key, s.Kind, tt.kind)
+			}
+			line := s.Lines[tt.line]
+			if len(line) != len(have.Times) || line[tt.index] == nil || *line[tt.index] != tt.want {
+				t.Fatalf("%s: %s[%d]\nhave %v\nwant %f", tt.key, tt.line, tt.index, line, tt.want)
+			}
+		}
+		if !found {
+			t.Fatalf("should include the series %s", tt.key)
+		}
+	}
+	for _, s := range have.Series {
+		if s.Kind == "gauge" && s.Lines["last"][1] != nil {
+			t.Fatalf("should not include a value for intervals without the key")
+		}
+	}
+}
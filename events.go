@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// subscriberBuffer is the number of completed intervals
+// buffered for a subscriber before the oldest are dropped.
+const subscriberBuffer = 16
+
+// Subscribe returns a channel that receives each interval as it
+// completes and a function that cancels the subscription. The
+// interval is shared with the other subscribers and the exporters
+// and must not be modified. The oldest buffered intervals are
+// dropped for a subscriber that falls behind rather than delaying
+// the intervals advancing. The channel is closed when the
+// subscription is cancelled or the metrics manager is closed.
+func (m *Metrics) Subscribe() (<-chan *Interval, func()) {
+	ch := make(chan *Interval, subscriberBuffer)
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	m.subscribers[ch] = struct{}{}
+	m.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if _, ok := m.subscribers[ch]; ok {
+				delete(m.subscribers, ch)
+				close(ch)
+			}
+		})
+	}
+}
+
+// publish sends the completed interval i to each subscriber without
+// blocking, dropping the oldest buffered interval of a subscriber
+// whose buffer is full. The caller must hold m.tick such that there
+// is a single sender.
+func (m *Metrics) publish(i *Interval) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for ch := range m.subscribers {
+		select {
+		case ch <- i:
+			continue
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- i:
+		default:
+		}
+	}
+}
+
+// EventsHandler returns an http.Handler that streams each completed
+// interval as a server-sent event in the JSON format of Interval.
+// The event ID is the interval time in Unix milliseconds. The prefix
+// and kind query parameters filter the metrics as for WindowHandler.
+// The oldest intervals are dropped for clients that fall behind.
+func EventsHandler(m *Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseWindowQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "metrics: streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		ch, cancel := m.Subscribe()
+		defer cancel()
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		f.Flush()
+		bw := bufio.NewWriter(w)
+		for {
+			select {
+			case i, ok := <-ch:
+				if !ok {
+					return
+				}
+				b, err := json.Marshal(&Interval{time: i.time, metrics: i.copyMetricsFunc(q.keep)})
+				if err != nil {
+					return
+				}
+				bw.WriteString("id: " + strconv.FormatInt(i.time.UnixMilli(), 10) + "\n")
+				bw.WriteString("event: interval\n")
+				bw.WriteString("data: ")
+				bw.Write(b)
+				bw.WriteString("\n\n")
+				if bw.Flush() != nil {
+					return
+				}
+				f.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
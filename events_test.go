@@ -0,0 +1,131 @@
+package metrics_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pnelson/metrics"
+)
+
+func TestMetricsSubscribe(t *testing.T) {
+	m, clock := newTestMetrics()
+	ch, cancel := m.Subscribe()
+	defer cancel()
+	m.Add([]string{"test"}, 1)
+	clock.Advance(testInterval)
+	m.Tick()
+	i := <-ch
+	if have := i.Counter([]string{"test"}).Value; have != 1 {
+		t.Fatalf("Counter\nhave %f\nwant %f", have, 1.0)
+	}
+	if !i.Time().Equal(testTime.Add(testInterval)) {
+		t.Fatalf("Time\nhave %v\nwant %v", i.Time(), testTime.Add(testInterval))
+	}
+	cancel()
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatalf("should close the channel on cancel")
+	}
+}
+
+func TestMetricsSubscribeSlow(t *testing.T) {
+	m, clock := newTestMetrics()
+	ch, cancel := m.Subscribe()
+	defer cancel()
+	for n := 0; n < 50; n++ {
+		m.Add([]string{"test"}, float64(n+1))
+		clock.Advance(testInterval)
+		m.Tick()
+	}
+	if len(ch) != cap(ch) {
+		t.Fatalf("should buffer intervals\nhave %d\nwant %d", len(ch), cap(ch))
+	}
+	want := float64(50 - cap(ch))
+	for len(ch) > 0 {
+		i := <-ch
+		want++
+		if have := i.Counter([]string{"test"}).Value; have != want {
+			t.Fatalf("should receive the latest intervals, dropping the oldest\nhave %f\nwant %f", have, want)
+		}
+	}
+	if want != 50 {
+		t.Fatalf("should receive the latest interval\nhave %f\nwant %f", want, 50.0)
+	}
+}
+
+func TestMetricsSubscribeClose(t *testing.T) {
+	m, _ := newTestMetrics()
+	ch, cancel := m.Subscribe()
+	defer cancel()
+	m.Add([]string{"test"}, 1)
+	err := m.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	i, ok := <-ch
+	if !ok || i.Counter([]string{"test"}).Value != 1 {
+		t.Fatalf("should receive the current interval on close")
+	}
+	if _, ok := <-ch; ok {
+		t.Fatalf("should close the channel on close")
+	}
+	ch, _ = m.Subscribe()
+	if _, ok := <-ch; ok {
+		t.Fatalf("should close the channel if subscribed after close")
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	m, clock := newTestMetrics()
+	srv := httptest.NewServer(metrics.EventsHandler(m))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "?prefix=/http")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Content-Type\nhave %q\nwant %q", resp.Header.Get("Content-Type"), "text/event-stream")
+	}
+	m.Add([]string{"http", "requests"}, 2)
+	m.Add([]string{"db", "queries"}, 3)
+	clock.Advance(testInterval)
+	m.Tick()
+	r := bufio.NewReader(resp.Body)
+	event := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		event[field] = value
+	}
+	if event["event"] != "interval" {
+		t.Fatalf("event\nhave %q\nwant %q", event["event"], "interval")
+	}
+	want := strconv.FormatInt(testTime.Add(testInterval).UnixMilli(), 10)
+	if event["id"] != want {
+		t.Fatalf("id\nhave %q\nwant %q", event["id"], want)
+	}
+	var i metrics.Interval
+	err = json.Unmarshal([]byte(event["data"]), &i)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if have := i.Counter([]string{"http", "requests"}).Value; have != 2 {
+		t.Fatalf("Counter\nhave %f\nwant %f", have, 2.0)
+	}
+	if have := i.Counter([]string{"db", "queries"}).Count; have != 0 {
+		t.Fatalf("should filter the metrics by prefix")
+	}
+}
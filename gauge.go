@@ -32,3 +32,35 @@ func (m *Gauge) Set(value float64) {
 	m.Value = value
 	m.Count++
 }
+
+// Merge merges the gauge values of o into the gauge. The
+// value of o is expected to have been set after the gauge.
+func (m *Gauge) Merge(o Gauge) {
+	if o.Count == 0 {
+		return
+	}
+	if m.Count == 0 {
+		*m = o
+		return
+	}
+	if o.Min < m.Min {
+		m.Min = o.Min
+	}
+	if o.Max > m.Max {
+		m.Max = o.Max
+	}
+	m.Value = o.Value
+	m.Count += o.Count
+}
+
+// storedGauge returns the stored gauge v counted as at least one
+// write. Gauges created at zero by NewGauge have a zero count but
+// are merged by presence, such that a gauge set to zero is the last
+// value.
+func storedGauge(v any) Gauge {
+	g := *v.(*Gauge)
+	if g.Count == 0 {
+		g.Count = 1
+	}
+	return g
+}
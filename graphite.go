@@ -0,0 +1,361 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Graphite exporter defaults and limits.
+const (
+	defaultGraphiteMinBackoff = 100 * time.Millisecond
+	defaultGraphiteMaxBackoff = time.Minute
+	graphiteTimeout           = 10 * time.Second
+	graphiteBuffer            = 100000 // datapoints
+	graphitePickleBatch       = 500    // datapoints per pickle message
+)
+
+// GraphiteExporter exports completed intervals to Graphite over TCP
+// in the Carbon plaintext protocol, or the pickle protocol. Key paths
+// are sent as dotted names with label names and values appended as
+// name segments. Datapoints are timestamped with the interval time.
+//
+// Counters are sent as the value. Gauges are expanded into the .min,
+// .max, .last and .count series. Histograms are expanded into the
+// .count, .dropped, .p50 and .p99 series. Summaries, sketches and HDR
+// histograms are expanded into the .count, .sum, .p50 and .p99 series.
+//
+// Datapoints that cannot be sent are buffered and retried with each
+// export, reconnecting with exponential backoff while the connection
+// is down. Datapoints written to the connection before a failure are
+// not sent again, although those not yet read by Carbon are lost. The oldest datapoints are discarded once the buffer of
+// 100000 datapoints is full.
+type GraphiteExporter struct {
+	addr       string
+	prefix     string
+	pickle     bool
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu      sync.Mutex
+	conn    net.Conn
+	backoff time.Duration
+	retry   time.Time
+	pending []graphiteDatapoint
+}
+
+// graphiteDatapoint represents a value of a series at a time.
+type graphiteDatapoint struct {
+	name  string
+	value float64
+	time  int64 // seconds
+}
+
+// countWriter counts the bytes written to the underlying writer.
+type countWriter struct {
+	w io.Writer
+	n int
+}
+
+// Write implements the io.Writer interface.
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += n
+	return n, err
+}
+
+// GraphiteOption represents a functional option for
+// configuring the Graphite exporter.
+type GraphiteOption func(*GraphiteExporter)
+
+// WithGraphitePickle sends the datapoints in the pickle protocol.
+func WithGraphitePickle() GraphiteOption {
+	return func(e *GraphiteExporter) {
+		e.pickle = true
+	}
+}
+
+// WithGraphitePrefix prefixes the names with the dotted prefix.
+func WithGraphitePrefix(prefix string) GraphiteOption {
+	return func(e *GraphiteExporter) {
+		e.prefix = strings.TrimSuffix(prefix, ".")
+	}
+}
+
+// WithGraphiteBackoff sets the minimum and maximum delay before
+// reconnecting. The delay doubles after each failed attempt.
+// The defaults are 100 milliseconds and 1 minute.
+func WithGraphiteBackoff(min, max time.Duration) GraphiteOption {
+	return func(e *GraphiteExporter) {
+		e.minBackoff = min
+		e.maxBackoff = max
+	}
+}
+
+// NewGraphiteExporter returns a new Graphite exporter sending to
+// the TCP network address addr. The connection is established
+// on the first export.
+func NewGraphiteExporter(addr string, opts ...GraphiteOption) *GraphiteExporter {
+	e := &GraphiteExporter{
+		addr:       addr,
+		minBackoff: defaultGraphiteMinBackoff,
+		maxBackoff: defaultGraphiteMaxBackoff,
+	}
+	for _, option := range opts {
+		option(e)
+	}
+	return e
+}
+
+// Export implements the Exporter interface. An error is returned
+// if the datapoints could not be sent and have been buffered, or
+// a *KeyError if a key of the interval could not be parsed.
+func (e *GraphiteExporter) Export(i *Interval) error {
+	t := i.Time().Unix()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	all, skipped := i.series()
+	for _, s := range all {
+		e.pending = append(e.pending, e.datapoints(s, t)...)
+	}
+	if len(e.pending) > graphiteBuffer {
+		e.pending = append(e.pending[:0], e.pending[len(e.pending)-graphiteBuffer:]...)
+	}
+	err := e.send(false)
+	if err != nil {
+		return err
+	}
+	return skipped
+}
+
+// Flush implements the Flusher interface. The buffered datapoints
+// are sent, reconnecting immediately if the connection is down.
+func (e *GraphiteExporter) Flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.send(true)
+}
+
+// Close closes the connection to Graphite.
+func (e *GraphiteExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		return nil
+	}
+	err := e.conn.Close()
+	e.conn = nil
+	return err
+}
+
+// send sends the buffered datapoints, connecting if required unless
+// waiting to reconnect and not forced. The caller must hold e.mu.
+func (e *GraphiteExporter) send(force bool) error {
+	if len(e.pending) == 0 {
+		return nil
+	}
+	if e.conn == nil {
+		if !force && time.Now().Before(e.retry) {
+			return nil
+		}
+		conn, err := net.DialTimeout("tcp", e.addr, graphiteTimeout)
+		if err != nil {
+			e.fail()
+			return err
+		}
+		e.conn = conn
+		e.backoff = 0
+	}
+	e.conn.SetWriteDeadline(time.Now().Add(graphiteTimeout))
+	cw := &countWriter{w: e.conn}
+	bw := bufio.NewWriter(cw)
+	var b []byte
+	for n, k := 0, 0; n < len(e.pending); n += k {
+		b, k = e.appendBatch(b[:0], e.pending[n:])
+		bw.Write(b)
+	}
+	err := bw.Flush()
+	if err != nil {
+		e.conn.Close()
+		e.conn = nil
+		e.fail()
+		e.trim(cw.n)
+		return err
+	}
+	e.pending = e.pending[:0]
+	return nil
+}
+
+// appendBatch appends the next message of the pending datapoints
+// to b, returning the number of datapoints in the message.
+func (e *GraphiteExporter) appendBatch(b []byte, pending []graphiteDatapoint) ([]byte, int) {
+	if !e.pickle {
+		return appendGraphiteLine(b, pending[0]), 1
+	}
+	if len(pending) > graphitePickleBatch {
+		pending = pending[:graphitePickleBatch]
+	}
+	return appendGraphitePickle(b, pending), len(pending)
+}
+
+// trim discards the pending datapoints of the messages written in
+// full to the connection within the first n bytes, such that only
+// the datapoints that were not written are sent again. The caller
+// must hold e.mu.
+func (e *GraphiteExporter) trim(n int) {
+	var b []byte
+	sent, size := 0, 0
+	for sent < len(e.pending) {
+		var k int
+		b, k = e.appendBatch(b[:0], e.pending[sent:])
+		size += len(b)
+		if size > n {
+			break
+		}
+		sent += k
+	}
+	e.pending = append(e.pending[:0], e.pending[sent:]...)
+}
+
+// fail schedules the next connection attempt after the
+// backoff delay, doubling the delay. The caller must hold e.mu.
+func (e *GraphiteExporter) fail() {
+	e.backoff *= 2
+	if e.backoff < e.minBackoff {
+		e.backoff = e.minBackoff
+	}
+	if e.backoff > e.maxBackoff {
+		e.backoff = e.maxBackoff
+	}
+	e.retry = time.Now().Add(e.backoff)
+}
+
+// datapoints returns the datapoints of the series at time t.
+func (e *GraphiteExporter) datapoints(s series, t int64) []graphiteDatapoint {
+	name := graphiteName(s.path)
+	if e.prefix != "" {
+		name = e.prefix + "." + name
+	}
+	for _, l := range s.labels {
+		name += "." + graphiteSegment(l.Name) + "." + graphiteSegment(l.Value)
+	}
+	var points []graphiteDatapoint
+	point := func(suffix string, value float64) {
+		if finite(value) {
+			points = append(points, graphiteDatapoint{name: name + suffix, value: value, time: t})
+		}
+	}
+	switch v := s.value.(type) {
+	case *Counter:
+		point("", v.Value)
+	case *Gauge:
+		point(".min", v.Min)
+		point(".max", v.Max)
+		point(".last", v.Value)
+		point(".count", float64(v.Count))
+	case *Histogram:
+		point(".count", float64(v.Count))
+		point(".dropped", float64(v.Dropped))
+		if v.Count > 0 {
+			point(".p50", v.Percentile(0.50))
+			point(".p99", v.Percentile(0.99))
+		}
+	default:
+		if p, ok := percentilesOf(v); ok {
+			point(".count", float64(p.count))
+			point(".sum", p.sum)
+			if p.count > 0 {
+				point(".p50", p.p50)
+				point(".p99", p.p99)
+			}
+		}
+	}
+	return points
+}
+
+// graphiteName returns the dotted Graphite name for the key path.
+func graphiteName(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for n, s := range segments {
+		segments[n] = graphiteSegment(s)
+	}
+	return strings.Join(segments, ".")
+}
+
+// graphiteReplacer replaces the characters reserved by the
+// Graphite plaintext protocol and tagged series with underscores.
+var graphiteReplacer = strings.NewReplacer(
+	".", "_", " ", "_", ";", "_", "=", "_", "\n", "_", "\r", "_", "\t", "_",
+)
+
+// graphiteSegment returns s with the reserved characters replaced.
+func graphiteSegment(s string) string {
+	return graphiteReplacer.Replace(s)
+}
+
+// appendGraphiteLine appends the datapoint d to b
+// as a line of the Carbon plaintext protocol.
+func appendGraphiteLine(b []byte, d graphiteDatapoint) []byte {
+	b = append(b, d.name...)
+	b = append(b, ' ')
+	b = strconv.AppendFloat(b, d.value, 'f', -1, 64)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, d.time, 10)
+	return append(b, '\n')
+}
+
+// Pickle protocol 2 opcodes.
+const (
+	pickleProto      = 0x80
+	pickleEmptyList  = ']'
+	pickleMark       = '('
+	pickleAppends    = 'e'
+	pickleBinUnicode = 'X'
+	pickleBinInt     = 'J'
+	pickleLong1      = 0x8a
+	pickleBinFloat   = 'G'
+	pickleTuple2     = 0x86
+	pickleStop       = '.'
+)
+
+// appendGraphitePickle appends the datapoints to b as a message of
+// the Carbon pickle protocol: the length of the payload as a 32-bit
+// big endian integer followed by the payload, a pickled list of
+// (name, (timestamp, value)) tuples.
+func appendGraphitePickle(b []byte, points []graphiteDatapoint) []byte {
+	start := len(b)
+	b = append(b, 0, 0, 0, 0, pickleProto, 2, pickleEmptyList, pickleMark)
+	for _, d := range points {
+		b = append(b, pickleBinUnicode)
+		b = appendUint32(b, uint32(len(d.name)))
+		b = append(b, d.name...)
+		if d.time >= math.MinInt32 && d.time <= math.MaxInt32 {
+			b = append(b, pickleBinInt)
+			b = appendUint32(b, uint32(int32(d.time)))
+		} else {
+			b = append(b, pickleLong1, 8)
+			b = appendUint64(b, uint64(d.time))
+		}
+		var value [8]byte
+		binary.BigEndian.PutUint64(value[:], math.Float64bits(d.value))
+		b = append(b, pickleBinFloat)
+		b = append(b, value[:]...)
+		b = append(b, pickleTuple2, pickleTuple2)
+	}
+	b = append(b, pickleAppends, pickleStop)
+	binary.BigEndian.PutUint32(b[start:], uint32(len(b)-start-4))
+	return b
+}
+
+// appendUint32 appends the little endian encoding of v to b.
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
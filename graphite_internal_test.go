@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// partialConn is a connection that accepts n bytes before failing.
+type partialConn struct {
+	net.Conn
+	n int
+}
+
+func (c *partialConn) Write(p []byte) (int, error) {
+	if len(p) > c.n {
+		n := c.n
+		c.n = 0
+		return n, errors.New("partial write")
+	}
+	c.n -= len(p)
+	return len(p), nil
+}
+
+func (c *partialConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (c *partialConn) Close() error { return nil }
+
+func TestGraphiteExporterPartialWrite(t *testing.T) {
+	pending := []graphiteDatapoint{{"a", 1, 1}, {"b", 2, 1}, {"c", 3, 1}}
+	e := NewGraphiteExporter("localhost:0")
+	e.pending = append(e.pending, pending...)
+	e.conn = &partialConn{n: len(appendGraphiteLine(nil, pending[0])) + 3}
+	if e.send(false) == nil {
+		t.Fatalf("should return the error writing")
+	}
+	if len(e.pending) != 2 || e.pending[0] != pending[1] || e.pending[1] != pending[2] {
+		t.Fatalf("should keep the datapoints not written\nhave %v\nwant %v", e.pending, pending[1:])
+	}
+}
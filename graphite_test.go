@@ -0,0 +1,190 @@
+package metrics_test
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pnelson/metrics"
+)
+
+// listenGraphite returns a loopback TCP listener standing in for Carbon.
+func listenGraphite(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+// decodeInterval decodes the interval from the JSON encoding.
+func decodeInterval(t *testing.T, s string) *metrics.Interval {
+	t.Helper()
+	i := new(metrics.Interval)
+	err := json.Unmarshal([]byte(s), i)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return i
+}
+
+func TestGraphiteExporter(t *testing.T) {
+	ln := listenGraphite(t)
+	e := metrics.NewGraphiteExporter(ln.Addr().String(), metrics.WithGraphitePrefix("app."))
+	defer e.Close()
+	m, clock := newTestMetrics()
+	m.Export(e)
+	m.Add([]string{"http", "requests"}, 3, metrics.Label{Name: "method", Value: "GET"})
+	m.Set([]string{"http", "active"}, 2)
+	m.Set([]string{"http", "active"}, 4)
+	m.Buckets([]string{"http", "latency"}, []metrics.Bucket{{Value: 10}, {Value: 20}})
+	m.Put([]string{"http", "latency"}, 10)
+	m.Put([]string{"http", "latency"}, 30)
+	m.Set([]string{"v1.2"}, 1)
+	m.Record([]string{"db"}, 100)
+	clock.Advance(testInterval)
+	m.Tick()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	ts := " " + strconv.FormatInt(testTime.Add(testInterval).Unix(), 10)
+	want := []string{
+		"app.db.count 1" + ts,
+		"app.db.p50 100" + ts,
+		"app.db.p99 100" + ts,
+		"app.db.sum 100" + ts,
+		"app.http.active.count 2" + ts,
+		"app.http.active.last 4" + ts,
+		"app.http.active.max 4" + ts,
+		"app.http.active.min 2" + ts,
+		"app.http.latency.count 2" + ts,
+		"app.http.latency.dropped 1" + ts,
+		"app.http.latency.p50 10" + ts,
+		"app.http.latency.p99 29.8" + ts,
+		"app.http.requests.method.GET 3" + ts,
+		"app.v1_2.count 1" + ts,
+		"app.v1_2.last 1" + ts,
+		"app.v1_2.max 1" + ts,
+		"app.v1_2.min 1" + ts,
+	}
+	r := bufio.NewReader(conn)
+	have := make([]string, len(want))
+	for n := range have {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		have[n] = strings.TrimSuffix(line, "\n")
+	}
+	sort.Strings(have)
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("lines\nhave %q\nwant %q", have, want)
+	}
+}
+
+func TestGraphiteExporterPickle(t *testing.T) {
+	ln := listenGraphite(t)
+	e := metrics.NewGraphiteExporter(ln.Addr().String(), metrics.WithGraphitePickle())
+	defer e.Close()
+	i := decodeInterval(t, `{"time":1640995200000,"metrics":{"/a/b:counter":{"min":1.5,"max":1.5,"value":1.5,"count":1}}}`)
+	err := e.Export(i)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, 4)
+	_, err = io.ReadFull(conn, header)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	payload := make([]byte, int(header[0])<<24|int(header[1])<<16|int(header[2])<<8|int(header[3]))
+	_, err = io.ReadFull(conn, payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// pickle.loads(payload) == [('a.b', (1640995200, 1.5))]
+	want := "80025d285803000000612e624a8099cf61473ff80000000000008686652e"
+	if have := hex.EncodeToString(payload); have != want {
+		t.Fatalf("payload\nhave %s\nwant %s", have, want)
+	}
+}
+
+func TestGraphiteExporterReconnect(t *testing.T) {
+	ln := listenGraphite(t)
+	lines := make(chan string, 100)
+	go func() {
+		first := true
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if first {
+				first = false
+				conn.Close()
+				continue
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					lines <- line
+				}
+			}()
+		}
+	}()
+	e := metrics.NewGraphiteExporter(ln.Addr().String(), metrics.WithGraphiteBackoff(time.Millisecond, 10*time.Millisecond))
+	defer e.Close()
+	deadline := time.After(5 * time.Second)
+	for n := int64(1); ; n++ {
+		i := decodeInterval(t, `{"time":`+strconv.FormatInt(n*1000, 10)+`,"metrics":{"/test:counter":{"min":1,"max":1,"value":1,"count":1}}}`)
+		e.Export(i)
+		select {
+		case line := <-lines:
+			if !strings.HasPrefix(line, "test 1 ") {
+				t.Fatalf("line\nhave %q\nwant prefix %q", line, "test 1 ")
+			}
+			return
+		case <-deadline:
+			t.Fatalf("timed out waiting to reconnect")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func TestGraphiteExporterUnavailable(t *testing.T) {
+	ln := listenGraphite(t)
+	addr := ln.Addr().String()
+	ln.Close()
+	e := metrics.NewGraphiteExporter(addr, metrics.WithGraphiteBackoff(time.Hour, time.Hour))
+	defer e.Close()
+	i := decodeInterval(t, `{"time":1000,"metrics":{"/test:counter":{"min":1,"max":1,"value":1,"count":1}}}`)
+	if e.Export(i) == nil {
+		t.Fatalf("should return the error connecting")
+	}
+	if e.Export(i) != nil {
+		t.Fatalf("should buffer the datapoints until reconnecting")
+	}
+}
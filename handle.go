@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// handle caches the cell for a canonical key within the current
+// interval so that recording does not look up the key while the
+// interval remains current.
+type handle struct {
+	m     *Metrics
+	key   string
+	entry atomic.Value // *handleEntry
+}
+
+// handleEntry represents the cell v at the key within interval i.
+type handleEntry struct {
+	i *Interval
+	v any
+}
+
+// newHandle returns a new handle for the key, labels and kind.
+// Invalid keys are reported to the error handler and return
+// a handle that discards values.
+func newHandle(m *Metrics, key []string, labels []Label, kind string) handle {
+	k, ok := m.seriesKey(key, labels, kind)
+	if !ok {
+		return handle{}
+	}
+	return handle{m: m, key: k}
+}
+
+// cached returns the current interval, read locked, and the cached
+// cell for the key within it, or nil if the cell is not cached. The
+// caller must release the read lock of a non-nil interval.
+func (h *handle) cached() (*Interval, any) {
+	e, ok := h.entry.Load().(*handleEntry)
+	if !ok || e.i != h.m.current.Load().(*Interval) {
+		return nil, nil
+	}
+	e.i.mu.RLock()
+	if e.i.frozen {
+		e.i.mu.RUnlock()
+		return nil, nil
+	}
+	return e.i, e.v
+}
+
+// cache caches the cell v for the key within interval i.
+func (h *handle) cache(i *Interval, v any) {
+	h.entry.Store(&handleEntry{i: i, v: v})
+}
+
+// CounterHandle represents a counter with a pre-resolved key.
+type CounterHandle struct {
+	handle
+}
+
+// CounterHandle returns a handle to record values for the counter
+// at key with the optional labels. The key is validated and resolved
+// once. Invalid keys are reported to the error handler and return a
+// handle that discards values.
+func (m *Metrics) CounterHandle(key []string, labels ...Label) *CounterHandle {
+	return &CounterHandle{handle: newHandle(m, key, labels, kindCounter)}
+}
+
+// Add adds value to the counter.
+func (h *CounterHandle) Add(value float64) {
+	if h.m == nil {
+		return
+	}
+	i, v := h.cached()
+	if i == nil {
+		i, v = h.m.acquire(h.key, newCounterMetric)
+		h.cache(i, v)
+	}
+	h.m.addCell(i, h.key, v, value)
+}
+
+// GaugeHandle represents a gauge with a pre-resolved key.
+type GaugeHandle struct {
+	handle
+}
+
+// GaugeHandle returns a handle to record values for the gauge at
+// key with the optional labels. The key is validated and resolved
+// once. Invalid keys are reported to the error handler and return
+// a handle that discards values.
+func (m *Metrics) GaugeHandle(key []string, labels ...Label) *GaugeHandle {
+	return &GaugeHandle{handle: newHandle(m, key, labels, kindGauge)}
+}
+
+// Set sets the gauge to value.
+func (h *GaugeHandle) Set(value float64) {
+	if h.m == nil {
+		return
+	}
+	i, v := h.cached()
+	if i == nil {
+		i, v = h.m.acquire(h.key, newGaugeMetric)
+		h.cache(i, v)
+	}
+	h.m.setCell(i, h.key, v, value)
+}
+
+// Mod modifies the gauge by relative value.
+func (h *GaugeHandle) Mod(value float64) {
+	if h.m == nil {
+		return
+	}
+	i, v := h.cached()
+	if i == nil {
+		var stored bool
+		i, v, stored = h.m.acquireGauge(h.key, value)
+		h.cache(i, v)
+		if stored {
+			i.mu.RUnlock()
+			return
+		}
+	}
+	h.m.modCell(i, h.key, v, value)
+}
+
+// HistogramHandle represents a histogram with a pre-resolved
+// key and bucket layout.
+type HistogramHandle struct {
+	handle
+	layout []Bucket
+}
+
+// HistogramHandle returns a handle to record samples for the
+// histogram at key with the optional labels. The key and buckets
+// are validated and resolved once, such that buckets set for the
+// key prefix afterwards do not apply to the handle. Invalid keys
+// are reported to the error handler and return a handle that
+// discards samples.
+func (m *Metrics) HistogramHandle(key []string, labels ...Label) *HistogramHandle {
+	h := &HistogramHandle{handle: newHandle(m, key, labels, kindHistogram)}
+	if h.m != nil {
+		m.mu.RLock()
+		h.layout = m.bucketsFor(h.key)
+		m.mu.RUnlock()
+	}
+	return h
+}
+
+// Put adds value as a sample.
+func (h *HistogramHandle) Put(value float64) {
+	if h.m == nil {
+		return
+	}
+	i, v := h.cached()
+	if i == nil {
+		i, v = h.m.acquire(h.key, h.newCell)
+		h.cache(i, v)
+	}
+	h.m.putCell(i, h.key, v, value, 1)
+}
+
+// newCell returns a new histogram cell with
+// the shards of interval i and the handle buckets.
+func (h *HistogramHandle) newCell(m *Metrics, k string, i *Interval) any {
+	return newHistogramCell(h.layout, i.shards)
+}
+
+// Timer adds the elapsed duration in milliseconds as a sample.
+func (h *HistogramHandle) Timer(t time.Time) {
+	if h.m == nil {
+		return
+	}
+	h.Put(float64(h.m.clock.Now().Sub(t).Milliseconds()))
+}
@@ -0,0 +1,247 @@
+package metrics_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pnelson/metrics"
+)
+
+func TestMetricsHandles(t *testing.T) {
+	m, clock := newTestMetrics()
+	m.Buckets([]string{"h"}, metrics.NewLinearBuckets(1, 1, 3))
+	label := metrics.Label{Name: "a", Value: "b"}
+	c := m.CounterHandle([]string{"c"}, label)
+	g := m.GaugeHandle([]string{"g"})
+	h := m.HistogramHandle([]string{"h"})
+	m.Buckets([]string{"h"}, metrics.NewLinearBuckets(1, 1, 10))
+	for n := 0; n < 3; n++ {
+		if n > 0 {
+			clock.Advance(testInterval)
+			m.Tick()
+		}
+		c.Add(1)
+		c.Add(2)
+		m.Add([]string{"c"}, 4, label)
+		g.Mod(2)
+		g.Mod(-1)
+		h.Put(1)
+		h.Put(5)
+		start := clock.Now()
+		clock.Advance(2 * time.Millisecond)
+		h.Timer(start)
+	}
+	w := m.Window()
+	if len(w.Intervals) != 3 {
+		t.Fatalf("should advance intervals\nhave %d\nwant %d", len(w.Intervals), 3)
+	}
+	for n := range w.Intervals {
+		i := &w.Intervals[n]
+		if c := i.Counter([]string{"c"}, label); c.Value != 7 || c.Count != 3 {
+			t.Fatalf("Counter[%d]\nhave %+v", n, c)
+		}
+		if g := i.Gauge([]string{"g"}); g.Value != float64(n+1) || g.Count != 2 {
+			t.Fatalf("Gauge[%d]\nhave %+v", n, g)
+		}
+		h := i.Histogram([]string{"h"})
+		if h.Count != 3 || h.Dropped != 1 || len(h.Buckets) != 3 || h.Buckets[1].Count != 1 {
+			t.Fatalf("Histogram[%d]\nhave %+v", n, h)
+		}
+	}
+	g.Set(10)
+	if v := m.Window().Intervals[2].Gauge([]string{"g"}).Value; v != 10 {
+		t.Fatalf("Gauge\nhave %f\nwant %f", v, 10.0)
+	}
+}
+
+func TestMetricsHandlesInvalidKey(t *testing.T) {
+	var errs []error
+	m := metrics.New(testWindow, testInterval,
+		metrics.WithClock(metrics.NewManualClock(testTime)),
+		metrics.WithErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+	m.CounterHandle(nil).Add(1)
+	m.GaugeHandle([]string{""}).Set(1)
+	m.HistogramHandle([]string{"a:b"}).Put(1)
+	if len(errs) != 3 {
+		t.Fatalf("should report invalid keys\nhave %v", errs)
+	}
+	w := m.Window()
+	b, err := w.Intervals[0].MarshalJSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(b), `"metrics":{}`) {
+		t.Fatalf("should discard values\nhave %s", b)
+	}
+}
+
+func BenchmarkMetricsAdd(b *testing.B) {
+	m, _ := newTestMetrics()
+	key := []string{"http", "requests"}
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		m.Add(key, 1)
+	}
+}
+
+func BenchmarkCounterHandleAdd(b *testing.B) {
+	m, _ := newTestMetrics()
+	c := m.CounterHandle([]string{"http", "requests"})
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		c.Add(1)
+	}
+}
+
+func BenchmarkMetricsPut(b *testing.B) {
+	m, _ := newTestMetrics()
+	key := []string{"http", "latency"}
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		m.Put(key, float64(n%1000))
+	}
+}
+
+func BenchmarkHistogramHandlePut(b *testing.B) {
+	m, _ := newTestMetrics()
+	h := m.HistogramHandle([]string{"http", "latency"})
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		h.Put(float64(n % 1000))
+	}
+}
+
+func BenchmarkMetricsAddParallel(b *testing.B) {
+	m, _ := newTestMetrics()
+	key := []string{"http", "requests"}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.Add(key, 1)
+		}
+	})
+}
+
+func BenchmarkCounterHandleAddParallel(b *testing.B) {
+	m, _ := newTestMetrics()
+	c := m.CounterHandle([]string{"http", "requests"})
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Add(1)
+		}
+	})
+}
+
+func BenchmarkMetricsSetParallel(b *testing.B) {
+	m, _ := newTestMetrics()
+	key := []string{"active"}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.Set(key, 1)
+		}
+	})
+}
+
+func BenchmarkMetricsPutParallel(b *testing.B) {
+	m, _ := newTestMetrics()
+	key := []string{"http", "latency"}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		n := 0
+		for pb.Next() {
+			m.Put(key, float64(n%1000))
+			n++
+		}
+	})
+}
+
+func BenchmarkHistogramHandlePutParallel(b *testing.B) {
+	m, _ := newTestMetrics()
+	h := m.HistogramHandle([]string{"http", "latency"})
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		n := 0
+		for pb.Next() {
+			h.Put(float64(n % 1000))
+			n++
+		}
+	})
+}
+
+// mutexMetrics records into a single interval as the recording path did
+// before cells, serializing writers on the lock of the interval. It is
+// the baseline for the parallel benchmarks.
+type mutexMetrics struct {
+	mu       sync.RWMutex
+	interval struct {
+		sync.Mutex
+		metrics map[string]any
+	}
+}
+
+func newMutexMetrics() *mutexMetrics {
+	m := &mutexMetrics{}
+	m.interval.metrics = make(map[string]any)
+	return m
+}
+
+func (m *mutexMetrics) Add(key []string, value float64) {
+	k := "/" + strings.Join(key, "/") + ":counter"
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i := &m.interval
+	i.Lock()
+	defer i.Unlock()
+	v, ok := i.metrics[k]
+	if !ok {
+		i.metrics[k] = metrics.NewCounter(value)
+		return
+	}
+	v.(*metrics.Counter).Add(value)
+}
+
+func (m *mutexMetrics) Put(key []string, value float64) {
+	k := "/" + strings.Join(key, "/") + ":histogram"
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i := &m.interval
+	i.Lock()
+	defer i.Unlock()
+	v, ok := i.metrics[k]
+	if !ok {
+		h := metrics.NewHistogram(metrics.NewDefaultLatencyBuckets())
+		h.Put(value)
+		i.metrics[k] = h
+		return
+	}
+	v.(*metrics.Histogram).Put(value)
+}
+
+func BenchmarkMutexAddParallel(b *testing.B) {
+	m := newMutexMetrics()
+	key := []string{"http", "requests"}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.Add(key, 1)
+		}
+	})
+}
+
+func BenchmarkMutexPutParallel(b *testing.B) {
+	m := newMutexMetrics()
+	key := []string{"http", "latency"}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		n := 0
+		for pb.Next() {
+			m.Put(key, float64(n%1000))
+			n++
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WindowHandler returns an http.Handler that serves the completed
+// intervals of the window at the finest resolution as JSON in the
+// format of Window. The intervals are filtered by the optional
+// query parameters:
+//
+//	prefix  key path prefix such as /http
+//	kind    metric kind such as counter, may be repeated
+//	since   earliest interval time as RFC 3339 or Unix milliseconds
+//	until   latest interval time as RFC 3339 or Unix milliseconds
+//	limit   maximum number of the latest intervals
+//
+// The prefix matches whole segments of the key path, such that
+// /http matches /http/requests but not /https.
+//
+// The ETag is derived from the served intervals and the query rather
+// than the response body, such that conditional requests are answered
+// with 304 Not Modified without encoding the intervals until they
+// change.
+func WindowHandler(m *Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseWindowQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.mu.RLock()
+		intervals := make([]*Interval, len(m.intervals)-1)
+		copy(intervals, m.intervals)
+		generation := m.generation
+		m.mu.RUnlock()
+		intervals = q.filter(intervals)
+		etag := q.etag(generation, intervals)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", etag)
+		if matchETag(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = writeWindowJSON(w, m.window, intervals, q.keep)
+		if err != nil {
+			m.errorHandler(err)
+		}
+	})
+}
+
+// windowQuery represents the query parameters of WindowHandler.
+type windowQuery struct {
+	prefix string
+	kinds  []string
+	since  time.Time
+	until  time.Time
+	limit  int
+}
+
+// parseWindowQuery parses the query parameters of WindowHandler.
+func parseWindowQuery(v url.Values) (windowQuery, error) {
+	q := windowQuery{prefix: v.Get("prefix")}
+	if q.prefix != "" && !strings.HasPrefix(q.prefix, "/") {
+		q.prefix = "/" + q.prefix
+	}
+	for _, kind := range v["kind"] {
+		kind = ":" + kind
+		if !knownKind(kind) {
+			return q, errors.New("metrics: invalid kind parameter")
+		}
+		q.kinds = append(q.kinds, kind)
+	}
+	var err error
+	q.since, err = parseQueryTime(v.Get("since"))
+	if err != nil {
+		return q, errors.New("metrics: invalid since parameter")
+	}
+	q.until, err = parseQueryTime(v.Get("until"))
+	if err != nil {
+		return q, errors.New("metrics: invalid until parameter")
+	}
+	if s := v.Get("limit"); s != "" {
+		q.limit, err = strconv.Atoi(s)
+		if err != nil || q.limit < 0 {
+			return q, errors.New("metrics: invalid limit parameter")
+		}
+	}
+	return q, nil
+}
+
+// parseQueryTime parses s as Unix milliseconds or RFC 3339.
+// The zero time is returned if s is empty.
+func parseQueryTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// filter returns the intervals within the time range,
+// limited to the latest intervals if there is a limit.
+func (q windowQuery) filter(intervals []*Interval) []*Interval {
+	var filtered []*Interval
+	for _, i := range intervals {
+		if !q.since.IsZero() && i.time.Before(q.since) {
+			continue
+		}
+		if !q.until.IsZero() && i.time.After(q.until) {
+			continue
+		}
+		filtered = append(filtered, i)
+	}
+	if q.limit > 0 && len(filtered) > q.limit {
+		filtered = filtered[len(filtered)-q.limit:]
+	}
+	return filtered
+}
+
+// etag returns the entity tag of the filtered intervals served for
+// the query. The generation of the intervals changes whenever they
+// are rotated or restored, and the time range and limit select a run
+// of consecutive intervals identified by its length and latest time.
+func (q windowQuery) etag(generation uint64, intervals []*Interval) string {
+	kinds := append([]string(nil), q.kinds...)
+	sort.Strings(kinds)
+	h := fnv.New64a()
+	var b []byte
+	b = strconv.AppendUint(b, generation, 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(len(intervals)), 10)
+	if len(intervals) > 0 {
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, intervals[len(intervals)-1].time.UnixNano(), 10)
+	}
+	b = append(b, ' ')
+	b = strconv.AppendQuote(b, q.prefix)
+	for _, kind := range kinds {
+		b = append(b, ' ')
+		b = append(b, kind...)
+	}
+	h.Write(b)
+	return `"` + strconv.FormatUint(h.Sum64(), 16) + `"`
+}
+
+// keep reports whether the canonical key k matches
+// the key path prefix and kinds of the query.
+func (q windowQuery) keep(k string) bool {
+	if !hasPathPrefix(k, q.prefix) {
+		return false
+	}
+	if len(q.kinds) == 0 {
+		return true
+	}
+	_, kind := splitKind(k)
+	for _, v := range q.kinds {
+		if kind == v {
+			return true
+		}
+	}
+	return false
+}
+
+// matchETag reports whether the If-None-Match header matches etag.
+func matchETag(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == etag || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeWindowJSON writes the intervals to w as a window of duration
+// in the JSON format of Window, copying each interval in turn with
+// the metrics for which keep returns true.
+func writeWindowJSON(w io.Writer, window time.Duration, intervals []*Interval, keep func(string) bool) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`{"duration":` + strconv.Itoa(int(window.Seconds())) + `,"intervals":[`)
+	enc := json.NewEncoder(bw)
+	for n, i := range intervals {
+		if n > 0 {
+			bw.WriteByte(',')
+		}
+		err := enc.Encode(&Interval{time: i.time, metrics: i.copyMetricsFunc(keep)})
+		if err != nil {
+			return err
+		}
+	}
+	bw.WriteString("]}\n")
+	return bw.Flush()
+}
@@ -0,0 +1,158 @@
+package metrics_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/pnelson/metrics"
+)
+
+// serveWindow serves the window handler with the query and
+// returns the response, decoding the body into w if non-nil.
+func serveWindow(t *testing.T, m *metrics.Metrics, query string, w *metrics.Window) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.WindowHandler(m).ServeHTTP(rec, httptest.NewRequest("GET", "/window?"+query, nil))
+	if w != nil && rec.Code == http.StatusOK {
+		err := json.Unmarshal(rec.Body.Bytes(), w)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return rec
+}
+
+func TestWindowHandler(t *testing.T) {
+	m, clock := newTestMetrics()
+	for n := 1; n <= 4; n++ {
+		m.Add([]string{"http", "requests"}, float64(n))
+		m.Set([]string{"http", "active"}, float64(n))
+		m.Put([]string{"db", "latency"}, float64(n))
+		clock.Advance(testInterval)
+		m.Tick()
+	}
+	m.Add([]string{"http", "requests"}, 100)
+	var w metrics.Window
+	rec := serveWindow(t, m, "", &w)
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Content-Type\nhave %q\nwant %q", rec.Header().Get("Content-Type"), "application/json")
+	}
+	if len(w.Intervals) != 4 {
+		t.Fatalf("should serve the completed intervals\nhave %d\nwant %d", len(w.Intervals), 4)
+	}
+	if have := w.Counter([]string{"http", "requests"}).Value; have != 10 {
+		t.Fatalf("Counter\nhave %f\nwant %f", have, 10.0)
+	}
+	first := w.Intervals[0].Time()
+	last := w.Intervals[3].Time()
+	tests := []struct {
+		query     string
+		intervals int
+		counter   float64
+		gauge     uint64
+		histogram uint64
+	}{
+		{"prefix=/http", 4, 10, 4, 0},
+		{"prefix=http/requests", 4, 10, 0, 0},
+		{"prefix=/http/req", 4, 0, 0, 0},
+		{"prefix=/htt", 4, 0, 0, 0},
+		{"kind=histogram", 4, 0, 0, 4},
+		{"kind=counter&kind=gauge", 4, 10, 4, 0},
+		{"since=" + strconv.FormatInt(first.Add(testInterval).UnixMilli(), 10), 3, 9, 3, 3},
+		{"until=" + first.Format("2006-01-02T15:04:05.999Z07:00"), 1, 1, 1, 1},
+		{"limit=2", 2, 7, 2, 2},
+		{"prefix=/http&limit=1&kind=gauge", 1, 0, 1, 0},
+		{"since=" + strconv.FormatInt(last.Add(testInterval).UnixMilli(), 10), 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		var w metrics.Window
+		rec := serveWindow(t, m, tt.query, &w)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status\nhave %d\nwant %d", tt.query, rec.Code, http.StatusOK)
+		}
+		if len(w.Intervals) != tt.intervals {
+			t.Fatalf("%s: intervals\nhave %d\nwant %d", tt.query, len(w.Intervals), tt.intervals)
+		}
+		if have := w.Counter([]string{"http", "requests"}).Value; have != tt.counter {
+			t.Fatalf("%s: Counter\nhave %f\nwant %f", tt.query, have, tt.counter)
+		}
+		if have := w.Gauge([]string{"http", "active"}).Count; have != tt.gauge {
+			t.Fatalf("%s: Gauge count\nhave %d\nwant %d", tt.query, have, tt.gauge)
+		}
+		h, err := w.Histogram([]string{"db", "latency"})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.query, err)
+		}
+		if h.Count != tt.histogram {
+			t.Fatalf("%s: Histogram count\nhave %d\nwant %d", tt.query, h.Count, tt.histogram)
+		}
+	}
+}
+
+func TestWindowHandlerInvalidQuery(t *testing.T) {
+	m, _ := newTestMetrics()
+	for _, query := range []string{"kind=meter", "since=yesterday", "until=1.5", "limit=-1", "limit=x"} {
+		rec := serveWindow(t, m, query, nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status\nhave %d\nwant %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestWindowHandlerETag(t *testing.T) {
+	m, clock := newTestMetrics()
+	m.Add([]string{"test"}, 1)
+	clock.Advance(testInterval)
+	m.Tick()
+	rec := serveWindow(t, m, "", nil)
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("should set the ETag")
+	}
+	if serveWindow(t, m, "prefix=/other", nil).Header().Get("ETag") == etag {
+		t.Fatalf("should change the ETag with the query")
+	}
+	if serveWindow(t, m, "limit=1", nil).Header().Get("ETag") != etag {
+		t.Fatalf("should not change the ETag for the same intervals")
+	}
+	r := httptest.NewRequest("GET", "/window", nil)
+	r.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	metrics.WindowHandler(m).ServeHTTP(rec, r)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("status\nhave %d\nwant %d", rec.Code, http.StatusNotModified)
+	}
+	if rec.Body.Len() != 0 {
+		t.Fatalf("should not write a body\nhave %q", rec.Body.String())
+	}
+	m.Add([]string{"test"}, 1)
+	clock.Advance(testInterval)
+	m.Tick()
+	rec = httptest.NewRecorder()
+	metrics.WindowHandler(m).ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status\nhave %d\nwant %d", rec.Code, http.StatusOK)
+	}
+	if rec.Header().Get("ETag") == etag {
+		t.Fatalf("should change the ETag as the intervals advance")
+	}
+	etag = rec.Header().Get("ETag")
+	var b bytes.Buffer
+	err := m.Snapshot(&b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	snapshot := bytes.Replace(b.Bytes(), []byte(`"value":1,`), []byte(`"value":2,`), 1)
+	err = m.Restore(bytes.NewReader(snapshot))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rec = serveWindow(t, m, "", nil)
+	if rec.Header().Get("ETag") == etag {
+		t.Fatalf("should change the ETag as the intervals change")
+	}
+}
@@ -0,0 +1,280 @@
+package metrics
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+const kindHDR = ":hdr"
+
+// DefaultSignificantDigits is the default number of
+// significant decimal digits of HDR histograms.
+const DefaultSignificantDigits = 3
+
+// ErrDigitsMismatch is returned when merging HDR histograms
+// that do not share the same number of significant digits.
+var ErrDigitsMismatch = errors.New("metrics: mismatched hdr histogram digits")
+
+// HdrHistogram V2 encoding cookies. The 0x10 bit marks
+// counts encoded as zig-zag LEB128 varints.
+const (
+	hdrEncodingCookie   = 0x1c849303 | 0x10
+	hdrCompressedCookie = 0x1c849304 | 0x10
+	hdrHeaderSize       = 40
+)
+
+// HDRHistogram represents a distribution of integer samples as an
+// HdrHistogram, counting samples in buckets that preserve the
+// significant decimal digits of every value from 1 to the largest
+// int64. At the default of 3 digits, latencies from a microsecond
+// to an hour are recorded to within 0.1%.
+//
+// Counts are keyed by the index of the bucket in the counts array
+// of an HdrHistogram with a lowest discernible value of 1, so that
+// the histogram is encoded in the standard HdrHistogram format.
+// Negative samples are ignored.
+type HDRHistogram struct {
+	Min    int64          `json:"min"`
+	Max    int64          `json:"max"`
+	Sum    float64        `json:"sum"`
+	Count  uint64         `json:"count"`
+	Digits int            `json:"digits"`
+	Counts map[int]uint64 `json:"counts"`
+}
+
+// NewHDRHistogram returns a new HDR histogram with the significant
+// digits. DefaultSignificantDigits is used if digits is not
+// between 1 and 5.
+func NewHDRHistogram(digits int) *HDRHistogram {
+	if digits < 1 || digits > 5 {
+		digits = DefaultSignificantDigits
+	}
+	return &HDRHistogram{Digits: digits}
+}
+
+// Put adds value as a sample.
+func (m *HDRHistogram) Put(value int64) {
+	if value < 0 {
+		return
+	}
+	if value < m.Min || m.Count == 0 {
+		m.Min = value
+	}
+	if value > m.Max || m.Count == 0 {
+		m.Max = value
+	}
+	m.Sum += float64(value)
+	m.Count++
+	if m.Counts == nil {
+		m.Counts = make(map[int]uint64)
+	}
+	m.Counts[m.index(value)]++
+}
+
+// PutCorrected adds value as a sample, correcting for coordinated
+// omission when a sample is expected every expectedInterval. A
+// value larger than expectedInterval means that the samples due
+// while value was measured were not taken, so the values they
+// would have measured are added from value less expectedInterval
+// down to expectedInterval. Only value is added if expectedInterval
+// is not positive. The corrected values are counted by bucket, such
+// that the cost is bounded by the number of buckets rather than the
+// number of corrected values.
+func (m *HDRHistogram) PutCorrected(value, expectedInterval int64) {
+	m.Put(value)
+	if expectedInterval <= 0 || value-expectedInterval < expectedInterval {
+		return
+	}
+	v := value - expectedInterval
+	for v >= expectedInterval {
+		i := m.index(v)
+		low, _ := m.bounds(i)
+		if low < expectedInterval {
+			low = expectedInterval
+		}
+		// The values v, v-expectedInterval, ... down to low.
+		n := (v-low)/expectedInterval + 1
+		m.Counts[i] += uint64(n)
+		m.Count += uint64(n)
+		m.Sum += float64(n) * (float64(v) - float64(n-1)*float64(expectedInterval)/2)
+		v -= n * expectedInterval
+	}
+	if last := v + expectedInterval; last < m.Min {
+		m.Min = last
+	}
+}
+
+// Merge merges the samples of o into the histogram. The digits of
+// o are adopted if the histogram has no digits or samples.
+// ErrDigitsMismatch is returned if the digits differ.
+func (m *HDRHistogram) Merge(o HDRHistogram) error {
+	if m.Count == 0 && (m.Digits == 0 || o.Count > 0) {
+		m.Digits = o.Digits
+	}
+	if o.Count == 0 {
+		return nil
+	}
+	if m.Digits != o.Digits {
+		return ErrDigitsMismatch
+	}
+	if o.Min < m.Min || m.Count == 0 {
+		m.Min = o.Min
+	}
+	if o.Max > m.Max || m.Count == 0 {
+		m.Max = o.Max
+	}
+	m.Sum += o.Sum
+	m.Count += o.Count
+	m.Counts = mergeBins(m.Counts, o.Counts)
+	return nil
+}
+
+// Percentile returns the highest value equivalent to the sample
+// below which a given percentage of the samples fall, within the
+// significant digits. The value is limited to the maximum sample.
+func (m HDRHistogram) Percentile(p float64) int64 {
+	if m.Count == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return m.Min
+	}
+	if p >= 1 {
+		return m.Max
+	}
+	rank := uint64(math.Ceil(p * float64(m.Count)))
+	n := uint64(0)
+	for _, i := range sortedBins(m.Counts) {
+		n += m.Counts[i]
+		if n >= rank {
+			_, high := m.bounds(i)
+			if high > m.Max {
+				return m.Max
+			}
+			return high
+		}
+	}
+	return m.Max
+}
+
+// Encode returns the histogram in the HdrHistogram V2 compressed
+// encoding, as written by the encodeIntoCompressedByteBuffer method
+// of the HdrHistogram libraries. The highest trackable value is the
+// highest value equivalent to the maximum sample.
+func (m HDRHistogram) Encode() []byte {
+	b := make([]byte, hdrHeaderSize, hdrHeaderSize+2*len(m.Counts))
+	highest := int64(2)
+	if m.Count > 0 {
+		limit := m.index(m.Max)
+		if _, high := m.bounds(limit); high > highest {
+			highest = high
+		}
+		b = m.appendCounts(b, limit)
+	}
+	binary.BigEndian.PutUint32(b[0:], hdrEncodingCookie)
+	binary.BigEndian.PutUint32(b[4:], uint32(len(b)-hdrHeaderSize))
+	binary.BigEndian.PutUint32(b[8:], 0) // normalizing index offset
+	binary.BigEndian.PutUint32(b[12:], uint32(m.digits()))
+	binary.BigEndian.PutUint64(b[16:], 1) // lowest discernible value
+	binary.BigEndian.PutUint64(b[24:], uint64(highest))
+	binary.BigEndian.PutUint64(b[32:], math.Float64bits(1)) // integer to double ratio
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 8))
+	zw := zlib.NewWriter(&buf)
+	// Writes to a bytes.Buffer do not fail.
+	zw.Write(b)
+	zw.Close()
+	c := buf.Bytes()
+	binary.BigEndian.PutUint32(c[0:], hdrCompressedCookie)
+	binary.BigEndian.PutUint32(c[4:], uint32(len(c)-8))
+	return c
+}
+
+// appendCounts appends the counts up to and including the index
+// limit as HdrHistogram varints. Runs of more than one empty
+// bucket are encoded as the negated length of the run.
+func (m *HDRHistogram) appendCounts(b []byte, limit int) []byte {
+	prev := -1
+	for _, i := range sortedBins(m.Counts) {
+		if i > limit {
+			break
+		}
+		switch zeros := i - prev - 1; {
+		case zeros == 1:
+			b = appendHDRVarint(b, 0)
+		case zeros > 1:
+			b = appendHDRVarint(b, -int64(zeros))
+		}
+		b = appendHDRVarint(b, int64(m.Counts[i]))
+		prev = i
+	}
+	return b
+}
+
+// appendHDRVarint appends v in the zig-zag LEB128 encoding of
+// HdrHistogram, which holds the last 8 bits in the ninth byte.
+func appendHDRVarint(b []byte, v int64) []byte {
+	u := uint64(v<<1) ^ uint64(v>>63)
+	for n := 0; n < 8; n++ {
+		if u < 0x80 {
+			return append(b, byte(u))
+		}
+		b = append(b, byte(u)|0x80)
+		u >>= 7
+	}
+	return append(b, byte(u))
+}
+
+// digits returns the significant digits of the histogram, or
+// DefaultSignificantDigits if the histogram has no digits.
+func (m *HDRHistogram) digits() int {
+	if m.Digits < 1 || m.Digits > 5 {
+		return DefaultSignificantDigits
+	}
+	return m.Digits
+}
+
+// magnitude returns the base 2 logarithm of the number of
+// sub-buckets of each bucket, the smallest power of two
+// not less than 2×10^digits.
+func (m *HDRHistogram) magnitude() int {
+	return bits.Len64(uint64(2*math.Pow10(m.digits())) - 1)
+}
+
+// index returns the index in the counts array for the value.
+// Bucket b holds values below 2^(magnitude+b) in steps of 2^b,
+// of which the lower half of the sub-buckets of every bucket
+// but the first are covered by the previous bucket.
+func (m *HDRHistogram) index(value int64) int {
+	magnitude := m.magnitude()
+	mask := uint64(1)<<magnitude - 1
+	bucket := 64 - magnitude - bits.LeadingZeros64(uint64(value)|mask)
+	sub := int(value >> bucket)
+	return (bucket+1)<<(magnitude-1) + sub - 1<<(magnitude-1)
+}
+
+// bounds returns the lowest and highest values
+// equivalent to the bucket at index i.
+func (m *HDRHistogram) bounds(i int) (int64, int64) {
+	half := m.magnitude() - 1
+	bucket := i>>half - 1
+	sub := int64(i&(1<<half-1) + 1<<half)
+	if bucket < 0 {
+		sub -= 1 << half
+		bucket = 0
+	}
+	low := sub << bucket
+	return low, low + 1<<bucket - 1
+}
+
+// copyHDRHistogram returns a deep copy of the histogram.
+func copyHDRHistogram(h *HDRHistogram) *HDRHistogram {
+	c := new(HDRHistogram)
+	*c = *h
+	c.Counts = mergeBins(nil, h.Counts)
+	return c
+}
@@ -0,0 +1,337 @@
+package metrics_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pnelson/metrics"
+)
+
+// inflateHDR returns the uncompressed HdrHistogram V2 encoding
+// of the HdrHistogram V2 compressed encoding.
+func inflateHDR(t *testing.T, b []byte) []byte {
+	t.Helper()
+	if len(b) < 8 || binary.BigEndian.Uint32(b) != 0x1c849314 || int(binary.BigEndian.Uint32(b[4:])) != len(b)-8 {
+		t.Fatalf("invalid compressed header %x", b)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(b[8:]))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return p
+}
+
+// decodeHDR decodes the HdrHistogram V2 compressed encoding
+// into the significant digits, highest trackable value and
+// the non-zero counts by index.
+func decodeHDR(t *testing.T, b []byte) (int, int64, map[int]uint64) {
+	t.Helper()
+	p := inflateHDR(t, b)
+	if len(p) < 40 || binary.BigEndian.Uint32(p) != 0x1c849313 || int(binary.BigEndian.Uint32(p[4:])) != len(p)-40 {
+		t.Fatalf("invalid header %x", p)
+	}
+	if lowest := binary.BigEndian.Uint64(p[16:]); lowest != 1 {
+		t.Fatalf("lowest discernible value\nhave %d\nwant 1", lowest)
+	}
+	digits := int(binary.BigEndian.Uint32(p[12:]))
+	highest := int64(binary.BigEndian.Uint64(p[24:]))
+	counts := make(map[int]uint64)
+	index := 0
+	for p = p[40:]; len(p) > 0; {
+		var u uint64
+		n := 0
+		for ; n < 9; n++ {
+			if n == 8 {
+				u |= uint64(p[n]) << 56
+				break
+			}
+			u |= uint64(p[n]&0x7f) << (7 * n)
+			if p[n] < 0x80 {
+				break
+			}
+		}
+		p = p[n+1:]
+		v := int64(u>>1) ^ -int64(u&1)
+		if v < 0 {
+			index += int(-v)
+			continue
+		}
+		if v > 0 {
+			counts[index] = uint64(v)
+		}
+		index++
+	}
+	return digits, highest, counts
+}
+
+func TestHDRHistogramPercentile(t *testing.T) {
+	m := metrics.NewHDRHistogram(metrics.DefaultSignificantDigits)
+	for v := int64(1); v <= 10000; v++ {
+		m.Put(v)
+	}
+	m.Put(-1)
+	if m.Count != 10000 || m.Min != 1 || m.Max != 10000 || m.Sum != 10000*10001/2 {
+		t.Fatalf("HDRHistogram\nhave count %d, min %d, max %d and sum %f", m.Count, m.Min, m.Max, m.Sum)
+	}
+	tests := []struct {
+		p    float64
+		want int64
+	}{
+		{0, 1},
+		{0.001, 10},
+		{0.5, 5003},
+		{0.9, 9007},
+		{0.99, 9903},
+		{1, 10000},
+	}
+	for _, tt := range tests {
+		have := m.Percentile(tt.p)
+		if have != tt.want {
+			t.Fatalf("Percentile(%v)\nhave %d\nwant %d", tt.p, have, tt.want)
+		}
+	}
+}
+
+func TestHDRHistogramPutCorrected(t *testing.T) {
+	m := metrics.NewHDRHistogram(metrics.DefaultSignificantDigits)
+	for n := 0; n < 9; n++ {
+		m.PutCorrected(10, 100)
+	}
+	m.PutCorrected(1000, 100)
+	if m.Count != 19 || m.Max != 1000 || m.Min != 10 {
+		t.Fatalf("PutCorrected\nhave count %d, min %d and max %d\nwant count 19, min 10 and max 1000", m.Count, m.Min, m.Max)
+	}
+	if have := m.Percentile(0.75); have != 600 {
+		t.Fatalf("Percentile\nhave %d\nwant %d", have, 600)
+	}
+	want := metrics.NewHDRHistogram(2)
+	have := metrics.NewHDRHistogram(2)
+	for v := int64(100000); v >= 7; v -= 7 {
+		want.Put(v)
+	}
+	have.PutCorrected(100000, 7)
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("PutCorrected\nhave count %d, min %d and sum %f\nwant count %d, min %d and sum %f", have.Count, have.Min, have.Sum, want.Count, want.Min, want.Sum)
+	}
+	m = metrics.NewHDRHistogram(metrics.DefaultSignificantDigits)
+	m.PutCorrected(1<<50, 1)
+	if m.Count != 1<<50 || m.Min != 1 {
+		t.Fatalf("PutCorrected\nhave count %d and min %d\nwant count %d and min 1", m.Count, m.Min, uint64(1<<50))
+	}
+}
+
+func TestHDRHistogramMerge(t *testing.T) {
+	a := metrics.NewHDRHistogram(2)
+	b := metrics.NewHDRHistogram(2)
+	all := metrics.NewHDRHistogram(2)
+	for v := int64(0); v < 100000; v += 7 {
+		if v%3 == 0 {
+			a.Put(v)
+		} else {
+			b.Put(v)
+		}
+		all.Put(v)
+	}
+	err := a.Merge(*b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(a, all) {
+		t.Fatalf("should merge exactly\nhave %v\nwant %v", a, all)
+	}
+	c := metrics.NewHDRHistogram(3)
+	c.Put(1)
+	err = a.Merge(*c)
+	if !errors.Is(err, metrics.ErrDigitsMismatch) {
+		t.Fatalf("Merge\nhave %v\nwant %v", err, metrics.ErrDigitsMismatch)
+	}
+}
+
+func TestHDRHistogramEncode(t *testing.T) {
+	m := metrics.NewHDRHistogram(metrics.DefaultSignificantDigits)
+	m.Put(1)
+	m.Put(1)
+	m.Put(5)
+	m.Put(6)
+	m.Put(3000)
+	digits, highest, counts := decodeHDR(t, m.Encode())
+	if digits != 3 || highest != 3001 {
+		t.Fatalf("Encode\nhave digits %d and highest %d\nwant digits 3 and highest 3001", digits, highest)
+	}
+	want := map[int]uint64{1: 2, 5: 1, 6: 1, 2524: 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Fatalf("Encode\nhave %v\nwant %v", counts, want)
+	}
+	m = metrics.NewHDRHistogram(metrics.DefaultSignificantDigits)
+	m.Put(1 << 62)
+	_, _, counts = decodeHDR(t, m.Encode())
+	if len(counts) != 1 {
+		t.Fatalf("Encode\nhave %v\nwant a single count", counts)
+	}
+	_, _, counts = decodeHDR(t, metrics.HDRHistogram{}.Encode())
+	if len(counts) != 0 {
+		t.Fatalf("Encode\nhave %v\nwant no counts", counts)
+	}
+}
+
+// hdrGolden is the uncompressed HdrHistogram V2 encoding of the
+// samples 1, 1, 5, 6 and 3000 with 3 significant digits, laid out
+// as by encodeIntoByteBuffer of the Java HdrHistogram library.
+const hdrGolden = "1c849313" + // encoding cookie
+	"00000008" + // payload length
+	"00000000" + // normalizing index offset
+	"00000003" + // significant digits
+	"0000000000000001" + // lowest discernible value
+	"0000000000000bb9" + // highest trackable value, 3001
+	"3ff0000000000000" + // integer to double ratio, 1.0
+	"00" + // index 0, a single zero count
+	"04" + // index 1, 2 samples of 1
+	"05" + // indexes 2 to 4, a run of 3 zero counts
+	"02" + // index 5, 1 sample of 5
+	"02" + // index 6, 1 sample of 6
+	"a927" + // indexes 7 to 2523, a run of 2517 zero counts
+	"02" // index 2524, 1 sample of 3000 to 3001
+
+func TestHDRHistogramEncodeGolden(t *testing.T) {
+	m := metrics.NewHDRHistogram(metrics.DefaultSignificantDigits)
+	for _, v := range []int64{1, 1, 5, 6, 3000} {
+		m.Put(v)
+	}
+	encoded := m.Encode()
+	if have := hex.EncodeToString(inflateHDR(t, encoded)); have != hdrGolden {
+		t.Fatalf("Encode\nhave %s\nwant %s", have, hdrGolden)
+	}
+	// Compressed histograms in HdrHistogram interval logs begin with
+	// the base64 of the compressed cookie and a payload length below
+	// 2^24.
+	if have := base64.StdEncoding.EncodeToString(encoded); !strings.HasPrefix(have, "HISTFAAAA") {
+		t.Fatalf("Encode\nhave %s\nwant prefix HISTFAAAA", have)
+	}
+}
+
+func TestMetricsRecord(t *testing.T) {
+	m, clock := newTestMetrics()
+	m.SignificantDigits([]string{"db"}, 2)
+	for n := 0; n < 3; n++ {
+		for v := 1; v <= 100; v++ {
+			m.Record([]string{"db", "latency"}, int64(n*100+v))
+		}
+		m.RecordCorrected([]string{"queue", "latency"}, 30, 10)
+		clock.Advance(testInterval)
+		m.Tick()
+	}
+	w := m.Window()
+	if have := w.Intervals[0].HDRHistogram([]string{"queue", "latency"}); have.Count != 3 || have.Min != 10 || have.Digits != metrics.DefaultSignificantDigits {
+		t.Fatalf("Interval.HDRHistogram\nhave %v\nwant 3 corrected samples with the default digits", have)
+	}
+	h, err := w.HDRHistogram([]string{"db", "latency"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.Count != 300 || h.Min != 1 || h.Max != 300 || h.Digits != 2 {
+		t.Fatalf("Window.HDRHistogram\nhave count %d, min %d, max %d and digits %d", h.Count, h.Min, h.Max, h.Digits)
+	}
+	if have := h.Percentile(0.9); have != 271 {
+		t.Fatalf("Percentile\nhave %d\nwant %d", have, 271)
+	}
+	b, err := json.Marshal(w)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var decoded metrics.Window
+	err = json.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	have, err := decoded.HDRHistogram([]string{"db", "latency"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(have, h) {
+		t.Fatalf("HDRHistogram after JSON\nhave %v\nwant %v", have, h)
+	}
+}
+
+func TestHDRLog(t *testing.T) {
+	m, clock := newTestMetrics()
+	label := metrics.Label{Name: "query", Value: "a, b"}
+	for n := 0; n < 2; n++ {
+		m.Record([]string{"db", "latency"}, int64(n+1)*1500, label)
+		m.Add([]string{"requests"}, 1)
+		clock.Advance(testInterval)
+		m.Tick()
+	}
+	w := m.Window()
+	var b bytes.Buffer
+	l := metrics.HDRLog{Window: w, Interval: testInterval, UnitRatio: 1000}
+	n, err := l.WriteTo(&b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != int64(b.Len()) {
+		t.Fatalf("WriteTo\nhave %d\nwant %d", n, b.Len())
+	}
+	var lines []string
+	s := bufio.NewScanner(&b)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	if len(lines) != 6 {
+		t.Fatalf("log\nhave %q\nwant 6 lines", lines)
+	}
+	start := strconv.FormatInt(testTime.Unix(), 10) + ".000"
+	want := []string{
+		"#[Histogram log format version 1.3]",
+		"#[StartTime: " + start + " (seconds since epoch), " + testTime.UTC().Format("Mon Jan 02 15:04:05 MST 2006") + "]",
+		"#[BaseTime: " + start + " (seconds since epoch)]",
+		`"StartTimestamp","Interval_Length","Interval_Max","Interval_Compressed_Histogram"`,
+	}
+	if !reflect.DeepEqual(lines[:4], want) {
+		t.Fatalf("log header\nhave %q\nwant %q", lines[:4], want)
+	}
+	prefix := []string{
+		`Tag=/db/latency{query="a__b"},0.000,0.010,1.500,`,
+		`Tag=/db/latency{query="a__b"},0.010,0.010,3.000,`,
+	}
+	for k, p := range prefix {
+		line := lines[4+k]
+		if !strings.HasPrefix(line, p) {
+			t.Fatalf("log line\nhave %s\nwant prefix %s", line, p)
+		}
+		encoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, p))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		h := w.Intervals[k].HDRHistogram([]string{"db", "latency"}, label)
+		if !bytes.Equal(encoded, h.Encode()) {
+			t.Fatalf("log histogram\nhave %x\nwant %x", encoded, h.Encode())
+		}
+	}
+	// The single sample of 1500 of the first line, as a run of
+	// 1500 zero counts followed by a count of 1 at index 1500.
+	want0 := "1c849313000000030000000000000003" +
+		"0000000000000001" + "00000000000005dc" + "3ff0000000000000" +
+		"b71702"
+	encoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(lines[4], prefix[0]))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if have := hex.EncodeToString(inflateHDR(t, encoded)); have != want0 {
+		t.Fatalf("log histogram\nhave %s\nwant %s", have, want0)
+	}
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// hdrLogUnitRatio is the default ratio of the values to the unit of
+// the maximum value column, as for HdrHistogram log writers.
+const hdrLogUnitRatio = 1e6
+
+// hdrLogTimeLayout is the layout of the human-readable start time.
+const hdrLogTimeLayout = "Mon Jan 02 15:04:05 MST 2006"
+
+// HDRLog writes the HDR histograms of a window in the HdrHistogram
+// interval log format 1.3 for the HdrHistogram log processing and
+// plotting tools.
+//
+// Each HDR histogram of each interval is written as a line tagged
+// with the key and labels, followed by the start of the interval in
+// seconds from the start of the first interval, the length of the
+// interval in seconds, the maximum sample divided by the unit ratio
+// and the histogram in the compressed encoding as base64. Commas and
+// white space in the tag, which delimit the fields, are replaced
+// with underscores. Keys that cannot be parsed are skipped and a
+// *KeyError is returned once the remaining lines are written.
+type HDRLog struct {
+	Window Window
+
+	// Interval is the duration of the intervals of the window.
+	Interval time.Duration
+
+	// UnitRatio is the ratio of the values to the unit of the
+	// maximum value column. The zero value is 1e6 as for the
+	// HdrHistogram log writers, which expect nanoseconds and
+	// report milliseconds. Use 1e3 for microseconds.
+	UnitRatio float64
+}
+
+// WriteTo implements the io.WriterTo interface.
+func (l HDRLog) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	ratio := l.UnitRatio
+	if !(ratio > 0) {
+		ratio = hdrLogUnitRatio
+	}
+	bw.WriteString("#[Histogram log format version 1.3]\n")
+	var base time.Time
+	if len(l.Window.Intervals) > 0 {
+		base = l.Window.Intervals[0].time.Add(-l.Interval)
+		seconds := float64(base.UnixMilli()) / 1000
+		fmt.Fprintf(bw, "#[StartTime: %.3f (seconds since epoch), %s]\n", seconds, base.UTC().Format(hdrLogTimeLayout))
+		fmt.Fprintf(bw, "#[BaseTime: %.3f (seconds since epoch)]\n", seconds)
+	}
+	bw.WriteString(`"StartTimestamp","Interval_Length","Interval_Max","Interval_Compressed_Histogram"` + "\n")
+	var skipped error
+	for n := range l.Window.Intervals {
+		i := &l.Window.Intervals[n]
+		start := i.time.Add(-l.Interval).Sub(base).Seconds()
+		all, err := i.series()
+		if err != nil && skipped == nil {
+			skipped = err
+		}
+		for _, s := range all {
+			h, ok := s.value.(*HDRHistogram)
+			if !ok {
+				continue
+			}
+			path, _ := splitKind(s.key)
+			encoded := base64.StdEncoding.EncodeToString(h.Encode())
+			fmt.Fprintf(bw, "Tag=%s,%.3f,%.3f,%.3f,%s\n", hdrLogTag(path), start, l.Interval.Seconds(), float64(h.Max)/ratio, encoded)
+		}
+	}
+	err := bw.Flush()
+	if err == nil {
+		err = skipped
+	}
+	return cw.n, err
+}
+
+// hdrLogTag returns the key as a log tag with commas
+// and white space replaced with underscores.
+func hdrLogTag(k string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ',' || unicode.IsSpace(r) {
+			return '_'
+		}
+		return r
+	}, k)
+}
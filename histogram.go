@@ -1,12 +1,27 @@
 package metrics
 
+import (
+	"errors"
+	"math"
+)
+
 const kindHistogram = ":histogram"
 
+// ErrBucketMismatch is returned when merging histograms
+// that do not share the same bucket values.
+var ErrBucketMismatch = errors.New("metrics: mismatched histogram buckets")
+
 // Histogram represents a distribution of metrics that count
 // the number of values that fall within configured buckets.
+//
+// Values above the last bucket value are counted by Dropped, the
+// overflow bucket ranging up to +Inf, and values below the first
+// bucket value are counted in the first bucket. The minimum and
+// maximum values bound the first and overflow buckets.
 type Histogram struct {
 	Min     float64  `json:"min"`
 	Max     float64  `json:"max"`
+	Sum     float64  `json:"sum"`
 	Count   uint64   `json:"count"`
 	Dropped uint64   `json:"dropped"`
 	Buckets []Bucket `json:"buckets"`
@@ -27,36 +42,96 @@ func NewHistogram(buckets []Bucket) *Histogram {
 
 // Put adds value as a sample.
 func (m *Histogram) Put(value float64) {
+	m.putN(value, 1)
+}
+
+// putN adds value as n samples.
+func (m *Histogram) putN(value float64, n uint64) {
 	if value < m.Min || m.Count == 0 {
 		m.Min = value
 	}
 	if value > m.Max || m.Count == 0 {
 		m.Max = value
 	}
-	m.Count++
+	m.Sum += value * float64(n)
+	m.Count += n
 	for i := range m.Buckets {
 		if value <= m.Buckets[i].Value {
-			m.Buckets[i].Count++
+			m.Buckets[i].Count += n
 			return
 		}
 	}
-	m.Dropped++
+	m.Dropped += n
+}
+
+// Merge merges the samples of o into the histogram. The buckets
+// of o are adopted if the histogram has no buckets or samples.
+// ErrBucketMismatch is returned if the bucket values differ.
+func (m *Histogram) Merge(o Histogram) error {
+	if o.Count == 0 {
+		return nil
+	}
+	if m.Count == 0 && len(m.Buckets) == 0 {
+		*m = o
+		m.Buckets = make([]Bucket, len(o.Buckets))
+		copy(m.Buckets, o.Buckets)
+		return nil
+	}
+	if len(m.Buckets) != len(o.Buckets) {
+		return ErrBucketMismatch
+	}
+	for i, b := range o.Buckets {
+		if m.Buckets[i].Value != b.Value {
+			return ErrBucketMismatch
+		}
+	}
+	if o.Min < m.Min || m.Count == 0 {
+		m.Min = o.Min
+	}
+	if o.Max > m.Max || m.Count == 0 {
+		m.Max = o.Max
+	}
+	for i, b := range o.Buckets {
+		m.Buckets[i].Count += b.Count
+	}
+	m.Sum += o.Sum
+	m.Count += o.Count
+	m.Dropped += o.Dropped
+	return nil
+}
+
+// Mean returns the mean of the samples.
+func (m Histogram) Mean() float64 {
+	if m.Count == 0 {
+		return 0
+	}
+	return m.Sum / float64(m.Count)
 }
 
-// Percentile returns the value below which a given
-// percentage of the samples fall.
+// Percentile returns the value below which a given percentage of
+// the samples fall, interpolated linearly within the bucket of the
+// sample between the bucket bounds limited to the minimum and
+// maximum values. Percentiles in the overflow bucket are
+// interpolated from the last bucket value toward the maximum.
 func (m Histogram) Percentile(p float64) float64 {
-	x0 := 0.0
+	if m.Count == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return m.Min
+	}
+	if p >= 1 {
+		return m.Max
+	}
+	rank := p * float64(m.Count)
+	x0 := math.Inf(-1)
 	sum := uint64(0)
 	for _, b := range m.Buckets {
-		y0 := float64(sum) / float64(m.Count)
-		y1 := float64(sum+b.Count) / float64(m.Count)
-		if y1 >= p {
-			x1 := b.Value
-			return x0 + (x1-x0)*((p-y0)/(y1-y0))
+		if b.Count > 0 && rank <= float64(sum+b.Count) {
+			return interpolate(math.Max(x0, m.Min), float64(sum), math.Min(b.Value, m.Max), float64(sum+b.Count), rank)
 		}
 		x0 = b.Value
 		sum += b.Count
 	}
-	return 0
+	return interpolate(math.Max(x0, m.Min), float64(sum), m.Max, float64(m.Count), rank)
 }
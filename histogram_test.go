@@ -0,0 +1,38 @@
+package metrics_test
+
+import (
+	"testing"
+
+	"github.com/pnelson/metrics"
+)
+
+func TestHistogramPercentile(t *testing.T) {
+	h := metrics.NewHistogram(metrics.NewLinearBuckets(10, 10, 2))
+	for _, v := range []float64{4, 8, 15, 60, 100} {
+		h.Put(v)
+	}
+	if h.Dropped != 2 || h.Mean() != 37.4 {
+		t.Fatalf("Histogram\nhave dropped %d and mean %f\nwant dropped 2 and mean 37.4", h.Dropped, h.Mean())
+	}
+	tests := []struct {
+		p    float64
+		want float64
+	}{
+		{0, 4},
+		{0.2, 7},
+		{0.4, 10},
+		{0.6, 20},
+		{0.8, 60},
+		{0.9, 80},
+		{1, 100},
+	}
+	for _, tt := range tests {
+		have := h.Percentile(tt.p)
+		if have != tt.want {
+			t.Fatalf("Percentile(%v)\nhave %f\nwant %f", tt.p, have, tt.want)
+		}
+	}
+	if have := (metrics.Histogram{}).Percentile(0.5); have != 0 {
+		t.Fatalf("Percentile of empty histogram\nhave %f\nwant 0", have)
+	}
+}
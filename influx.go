@@ -0,0 +1,283 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Influx exporter defaults and limits.
+const (
+	influxContentType = "text/plain; charset=utf-8"
+	influxTimeout     = 10 * time.Second
+)
+
+// errorBodySize is the number of bytes of
+// the response body included in errors.
+const errorBodySize = 512
+
+// InfluxLines writes an interval in the InfluxDB line protocol.
+//
+// Each series is written as a point of the measurement named by the
+// key path without the leading slash, tagged with the labels and
+// timestamped with the interval time in the precision. The fields of
+// counters and gauges are min, max, value and count. The fields of
+// histograms are min, max, sum, count, dropped and a bucket_<value>
+// field with the count of each bucket. The fields of summaries,
+// sketches and HDR histograms are min, max, sum, count, p50 and p99.
+// Fields that are not finite are omitted. Keys that cannot be parsed
+// are skipped and a *KeyError is returned once the remaining lines
+// are written.
+type InfluxLines struct {
+	Interval *Interval
+
+	// Precision is the timestamp precision. It is truncated to the
+	// nearest supported precision of time.Second, time.Millisecond,
+	// time.Microsecond or time.Nanosecond. The zero value is
+	// time.Nanosecond.
+	Precision time.Duration
+}
+
+// WriteTo implements the io.WriterTo interface.
+func (l InfluxLines) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	precision, _ := influxPrecision(l.Precision)
+	ts := l.Interval.Time().UnixNano() / int64(precision)
+	var b []byte
+	all, skipped := l.Interval.series()
+	for _, s := range all {
+		b = appendInfluxLine(b[:0], s, ts)
+		if len(b) > 0 {
+			bw.Write(b)
+		}
+	}
+	err := bw.Flush()
+	if err == nil {
+		err = skipped
+	}
+	return cw.n, err
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements the io.Writer interface.
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// InfluxExporter exports completed intervals to the InfluxDB 2.x
+// HTTP write API as InfluxLines.
+type InfluxExporter struct {
+	url       string
+	token     string
+	precision time.Duration
+	client    *http.Client
+}
+
+// InfluxOption represents a functional option for
+// configuring the InfluxDB exporter.
+type InfluxOption func(*InfluxExporter)
+
+// WithInfluxToken sets the API token used to authorize writes.
+func WithInfluxToken(token string) InfluxOption {
+	return func(e *InfluxExporter) {
+		e.token = token
+	}
+}
+
+// WithInfluxPrecision sets the timestamp precision.
+// The default is time.Second.
+func WithInfluxPrecision(precision time.Duration) InfluxOption {
+	return func(e *InfluxExporter) {
+		e.precision = precision
+	}
+}
+
+// WithInfluxClient sets the HTTP client used to send writes.
+// The default client times out after 10 seconds.
+func WithInfluxClient(client *http.Client) InfluxOption {
+	return func(e *InfluxExporter) {
+		e.client = client
+	}
+}
+
+// NewInfluxExporter returns a new InfluxDB exporter writing to
+// the bucket of the organization at the server base URL addr,
+// such as http://localhost:8086.
+func NewInfluxExporter(addr, org, bucket string, opts ...InfluxOption) *InfluxExporter {
+	e := &InfluxExporter{
+		precision: time.Second,
+		client:    &http.Client{Timeout: influxTimeout},
+	}
+	for _, option := range opts {
+		option(e)
+	}
+	precision, name := influxPrecision(e.precision)
+	e.precision = precision
+	query := url.Values{}
+	query.Set("org", org)
+	query.Set("bucket", bucket)
+	query.Set("precision", name)
+	e.url = strings.TrimSuffix(addr, "/") + "/api/v2/write?" + query.Encode()
+	return e
+}
+
+// Export implements the Exporter interface. An error is returned
+// if the write could not be sent or was not accepted by the server,
+// or a *KeyError if a key of the interval could not be parsed.
+func (e *InfluxExporter) Export(i *Interval) error {
+	var body bytes.Buffer
+	_, skipped := InfluxLines{Interval: i, Precision: e.precision}.WriteTo(&body)
+	if body.Len() == 0 {
+		return skipped
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", influxContentType)
+	if e.token != "" {
+		req.Header.Set("Authorization", "Token "+e.token)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = responseError("influx write", resp)
+	if err != nil {
+		return err
+	}
+	return skipped
+}
+
+// responseError returns an error with the status and the start of
+// the body of the response to the request op if the response status
+// is not successful. The body is read to completion.
+func responseError(op string, resp *http.Response) error {
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodySize))
+		return fmt.Errorf("metrics: %s: %s: %s", op, resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// influxPrecision returns the supported precision that d is truncated
+// to and the name of the precision in the write API.
+func influxPrecision(d time.Duration) (time.Duration, string) {
+	switch {
+	case d >= time.Second:
+		return time.Second, "s"
+	case d >= time.Millisecond:
+		return time.Millisecond, "ms"
+	case d >= time.Microsecond:
+		return time.Microsecond, "us"
+	}
+	return time.Nanosecond, "ns"
+}
+
+// appendInfluxLine appends the series s at timestamp ts to b as
+// a line of the InfluxDB line protocol. Nothing is appended if
+// the series has no finite fields.
+func appendInfluxLine(b []byte, s series, ts int64) []byte {
+	start := len(b)
+	b = append(b, influxMeasurementReplacer.Replace(strings.TrimPrefix(s.path, "/"))...)
+	for _, l := range s.labels {
+		if l.Value == "" {
+			continue
+		}
+		b = append(b, ',')
+		b = append(b, l.Name...)
+		b = append(b, '=')
+		b = append(b, influxTagReplacer.Replace(l.Value)...)
+	}
+	fields := len(b)
+	float := func(name string, value float64) {
+		if !finite(value) {
+			return
+		}
+		if len(b) == fields {
+			b = append(b, ' ')
+		} else {
+			b = append(b, ',')
+		}
+		b = append(b, name...)
+		b = append(b, '=')
+		b = strconv.AppendFloat(b, value, 'g', -1, 64)
+	}
+	integer := func(name string, value uint64) {
+		if len(b) == fields {
+			b = append(b, ' ')
+		} else {
+			b = append(b, ',')
+		}
+		b = append(b, name...)
+		b = append(b, '=')
+		b = strconv.AppendUint(b, value, 10)
+		b = append(b, 'i')
+	}
+	switch v := s.value.(type) {
+	case *Counter:
+		float("min", v.Min)
+		float("max", v.Max)
+		float("value", v.Value)
+		integer("count", v.Count)
+	case *Gauge:
+		float("min", v.Min)
+		float("max", v.Max)
+		float("value", v.Value)
+		integer("count", v.Count)
+	case *Histogram:
+		float("min", v.Min)
+		float("max", v.Max)
+		float("sum", v.Sum)
+		integer("count", v.Count)
+		integer("dropped", v.Dropped)
+		for _, bucket := range v.Buckets {
+			if finite(bucket.Value) {
+				integer("bucket_"+strconv.FormatFloat(bucket.Value, 'g', -1, 64), bucket.Count)
+			}
+		}
+	default:
+		if p, ok := percentilesOf(v); ok {
+			float("min", p.min)
+			float("max", p.max)
+			float("sum", p.sum)
+			integer("count", p.count)
+			if p.count > 0 {
+				float("p50", p.p50)
+				float("p99", p.p99)
+			}
+		}
+	}
+	if len(b) == fields {
+		return b[:start]
+	}
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, ts, 10)
+	return append(b, '\n')
+}
+
+// influxMeasurementReplacer escapes the characters
+// reserved within InfluxDB measurement names.
+var influxMeasurementReplacer = strings.NewReplacer(`\`, `\\`, ",", `\,`, " ", `\ `)
+
+// influxTagReplacer escapes the characters reserved within
+// InfluxDB tag values and replaces line breaks with spaces.
+var influxTagReplacer = strings.NewReplacer(
+	`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\ `, "\r", `\ `,
+)
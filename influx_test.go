@@ -0,0 +1,109 @@
+package metrics_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pnelson/metrics"
+)
+
+func TestInfluxLines(t *testing.T) {
+	i := decodeInterval(t, `{"time":1640995200000,"metrics":{`+
+		`"/http/requests{method=\"GET\",path=\"/a b,c\"}:counter":{"min":1,"max":2,"value":3,"count":2},`+
+		`"/http/active:gauge":{"min":-1,"max":4,"value":2,"count":3},`+
+		`"/db:summary":{"min":100,"max":100,"sum":100,"count":1,"compression":100,"centroids":[[100,1]]},`+
+		`"/http/latency:histogram":{"min":5,"max":50,"sum":65,"count":3,"dropped":1,"buckets":[[10,2],[20,0]]}}}`)
+	var buf bytes.Buffer
+	n, err := metrics.InfluxLines{Interval: i, Precision: time.Millisecond}.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `db min=100,max=100,sum=100,count=1i,p50=100,p99=100 1640995200000
+http/active min=-1,max=4,value=2,count=3i 1640995200000
+http/latency min=5,max=50,sum=65,count=3i,dropped=1i,bucket_10=2i,bucket_20=0i 1640995200000
+http/requests,method=GET,path=/a\ b\,c min=1,max=2,value=3,count=2i 1640995200000
+`
+	if have := buf.String(); have != want {
+		t.Fatalf("lines\nhave %q\nwant %q", have, want)
+	}
+	if n != int64(len(want)) {
+		t.Fatalf("WriteTo\nhave %d\nwant %d", n, len(want))
+	}
+}
+
+func TestInfluxLinesPrecision(t *testing.T) {
+	i := decodeInterval(t, `{"time":1640995200123,"metrics":{"/a:counter":{"min":1,"max":1,"value":1,"count":1}}}`)
+	tests := []struct {
+		precision time.Duration
+		want      string
+	}{
+		{0, "1640995200123000000"},
+		{time.Microsecond, "1640995200123000"},
+		{time.Millisecond, "1640995200123"},
+		{time.Minute, "1640995200"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		_, err := metrics.InfluxLines{Interval: i, Precision: tt.precision}.WriteTo(&buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if have := buf.String(); !strings.HasSuffix(have, " "+tt.want+"\n") {
+			t.Fatalf("%v\nhave %q\nwant timestamp %s", tt.precision, have, tt.want)
+		}
+	}
+}
+
+func TestInfluxExporter(t *testing.T) {
+	var have *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		have = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	e := metrics.NewInfluxExporter(srv.URL, "acme", "app", metrics.WithInfluxToken("secret"))
+	m, clock := newTestMetrics()
+	m.Export(e)
+	m.Add([]string{"http", "requests"}, 3, metrics.Label{Name: "method", Value: "GET"})
+	clock.Advance(testInterval)
+	m.Tick()
+	if have == nil {
+		t.Fatalf("should write the completed interval")
+	}
+	if have.Method != http.MethodPost || have.URL.Path != "/api/v2/write" {
+		t.Fatalf("request\nhave %s %s\nwant POST /api/v2/write", have.Method, have.URL.Path)
+	}
+	query := have.URL.Query()
+	if query.Get("org") != "acme" || query.Get("bucket") != "app" || query.Get("precision") != "s" {
+		t.Fatalf("query\nhave %v\nwant org acme, bucket app and precision s", query)
+	}
+	if auth := have.Header.Get("Authorization"); auth != "Token secret" {
+		t.Fatalf("Authorization\nhave %q\nwant %q", auth, "Token secret")
+	}
+	ts := testTime.Add(testInterval).Unix()
+	want := "http/requests,method=GET min=3,max=3,value=3,count=1i " + strconv.FormatInt(ts, 10) + "\n"
+	if string(body) != want {
+		t.Fatalf("body\nhave %q\nwant %q", body, want)
+	}
+}
+
+func TestInfluxExporterError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"code":"unauthorized"}`, http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	e := metrics.NewInfluxExporter(srv.URL, "acme", "app")
+	i := decodeInterval(t, `{"time":1000,"metrics":{"/a:counter":{"min":1,"max":1,"value":1,"count":1}}}`)
+	err := e.Export(i)
+	if err == nil || !strings.Contains(err.Error(), "401") || !strings.Contains(err.Error(), "unauthorized") {
+		t.Fatalf("Export\nhave %v\nwant the response status and body", err)
+	}
+}
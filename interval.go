@@ -3,7 +3,8 @@ package metrics
 import (
 	"encoding/json"
 	"fmt"
-	"strings"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -19,6 +20,8 @@ type Interval struct {
 	mu      sync.RWMutex
 	time    time.Time
 	metrics map[string]any
+	shards  int  // histogram shards of the cells of a live interval
+	frozen  bool // cells replaced with plain metrics
 }
 
 // newInterval returns a new interval of aggregated metrics.
@@ -29,29 +32,280 @@ func newInterval(t time.Time) *Interval {
 	}
 }
 
+// newLiveInterval returns a new interval that records metrics as
+// cells with n histogram shards for concurrent writers.
+func newLiveInterval(t time.Time, n int) *Interval {
+	i := newInterval(t)
+	i.shards = n
+	return i
+}
+
+// load returns the metric at the canonical key k.
+func (i *Interval) load(k string) (any, bool) {
+	i.mu.RLock()
+	v, ok := i.metrics[k]
+	i.mu.RUnlock()
+	return v, ok
+}
+
+// live reports whether the interval records metrics as cells.
+// The caller must hold i.mu or the lock of the ring of intervals.
+func (i *Interval) live() bool {
+	return i.shards > 0 && !i.frozen
+}
+
+// freeze replaces the cells of a live interval with plain metrics
+// once the interval is no longer current. Freezing waits for writers
+// holding the read lock, and writers that lock a frozen interval
+// retry at the current interval. The caller must hold the lock of
+// the ring of intervals.
+func (i *Interval) freeze() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for k, v := range i.metrics {
+		if c, ok := v.(cell); ok {
+			i.metrics[k] = c.metric()
+		}
+	}
+	i.frozen = true
+}
+
 // Time returns the interval time.
 func (i *Interval) Time() time.Time {
 	return i.time
 }
 
-// Counter returns the counter at key if it exists.
-func (i *Interval) Counter(key []string) Counter {
-	k := keyPath(key) + kindCounter
+// snapshot returns a copy of the interval.
+func (i *Interval) snapshot() *Interval {
+	return &Interval{time: i.time, metrics: i.copyMetrics()}
+}
+
+// copyMetrics returns a deep copy of the interval metrics.
+func (i *Interval) copyMetrics() map[string]any {
+	return i.copyMetricsFunc(nil)
+}
+
+// copyMetricsFunc returns a deep copy of the interval metrics
+// with the canonical keys for which keep returns true, or every
+// metric if keep is nil.
+func (i *Interval) copyMetricsFunc(keep func(k string) bool) map[string]any {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	metrics := make(map[string]any, len(i.metrics))
+	for k, v := range i.metrics {
+		if keep != nil && !keep(k) {
+			continue
+		}
+		switch t := v.(type) {
+		case cell:
+			metrics[k] = t.metric()
+		case *Counter:
+			c := new(Counter)
+			*c = *t
+			metrics[k] = c
+		case *Gauge:
+			g := new(Gauge)
+			*g = *t
+			metrics[k] = g
+		case *Histogram:
+			h := new(Histogram)
+			*h = *t
+			h.Buckets = make([]Bucket, len(t.Buckets))
+			copy(h.Buckets, t.Buckets)
+			metrics[k] = h
+		case *Summary:
+			metrics[k] = copySummary(t)
+		case *Sketch:
+			metrics[k] = copySketch(t)
+		case *HDRHistogram:
+			metrics[k] = copyHDRHistogram(t)
+		default:
+			panic("metrics: unexpected metric type")
+		}
+	}
+	return metrics
+}
+
+// merge merges the metrics of o into the interval. Counters are
+// summed, gauges retain the last value of o, histograms, sketches
+// and HDR histograms are merged bucket-wise and summaries are merged
+// centroid-wise. The first error encountered merging a bucketed
+// metric is returned after merging the remaining metrics.
+func (i *Interval) merge(o *Interval) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var err error
+	for k, v := range o.metrics {
+		dst, ok := i.metrics[k]
+		if !ok {
+			dst = i.newMetric(v)
+			i.metrics[k] = dst
+		}
+		var mergeErr error
+		switch t := dst.(type) {
+		case *Counter:
+			t.Merge(storedCounter(v))
+		case *counterCell:
+			t.merge(storedCounter(v))
+		case *Gauge:
+			t.Merge(storedGauge(v))
+		case *gaugeCell:
+			t.merge(storedGauge(v))
+		case *Histogram:
+			mergeErr = t.Merge(*v.(*Histogram))
+		case *histogramCell:
+			mergeErr = t.merge(*v.(*Histogram))
+		case *Summary:
+			t.Merge(*v.(*Summary))
+		case *summaryCell:
+			t.merge(*v.(*Summary))
+		case *Sketch:
+			mergeErr = t.Merge(*v.(*Sketch))
+		case *sketchCell:
+			mergeErr = t.merge(*v.(*Sketch))
+		case *HDRHistogram:
+			mergeErr = t.Merge(*v.(*HDRHistogram))
+		case *hdrCell:
+			mergeErr = t.merge(*v.(*HDRHistogram))
+		}
+		if mergeErr != nil && err == nil {
+			err = fmt.Errorf("%w for %s", mergeErr, k)
+		}
+	}
+	return err
+}
+
+// newMetric returns an empty metric of the same kind as v,
+// or an empty cell if the interval is live.
+func (i *Interval) newMetric(v any) any {
+	switch t := v.(type) {
+	case *Counter:
+		if i.live() {
+			return newCounterCell()
+		}
+		return new(Counter)
+	case *Gauge:
+		if i.live() {
+			return newGaugeCell()
+		}
+		return new(Gauge)
+	case *Histogram:
+		if i.live() {
+			return newHistogramCell(t.Buckets, i.shards)
+		}
+		return new(Histogram)
+	case *Summary:
+		if i.live() {
+			return newSummaryCell(t.Compression, i.shards)
+		}
+		return new(Summary)
+	case *Sketch:
+		if i.live() {
+			return newSketchCell(t.Accuracy, i.shards)
+		}
+		return new(Sketch)
+	case *HDRHistogram:
+		if i.live() {
+			return newHDRCell(t.Digits, i.shards)
+		}
+		return new(HDRHistogram)
+	}
+	panic("metrics: unexpected metric type")
+}
+
+// series represents a single metric within an interval.
+type series struct {
+	key    string
+	path   string
+	labels []Label
+	kind   string
+	value  any
+}
+
+// series returns the metrics of the interval sorted by key path,
+// kind and labels such that series of the same metric are adjacent.
+// Keys that cannot be parsed are skipped, and a *KeyError is returned
+// for the first skipped key in sorted order along with the series.
+func (i *Interval) series() ([]series, error) {
+	s := make([]series, 0, len(i.metrics))
+	var skipped *KeyError
+	for k, v := range i.metrics {
+		path, labels, kind, err := parseKey(k)
+		if err != nil {
+			if skipped == nil || k < skipped.Key {
+				skipped = &KeyError{Key: k, Err: err}
+			}
+			continue
+		}
+		s = append(s, series{key: k, path: path, labels: labels, kind: kind, value: v})
+	}
+	sort.Slice(s, func(a, b int) bool {
+		if s[a].path != s[b].path {
+			return s[a].path < s[b].path
+		}
+		if s[a].kind != s[b].kind {
+			return s[a].kind < s[b].kind
+		}
+		return s[a].key < s[b].key
+	})
+	if skipped != nil {
+		return s, skipped
+	}
+	return s, nil
+}
+
+// percentiles represents the statistics exported for summaries,
+// sketches and HDR histograms, which estimate percentiles rather
+// than count samples within configured buckets.
+type percentiles struct {
+	min, max, sum float64
+	count         uint64
+	p50, p99      float64
+}
+
+// percentilesOf returns the statistics of v if v is a summary,
+// sketch or HDR histogram.
+func percentilesOf(v any) (percentiles, bool) {
+	switch t := v.(type) {
+	case *Summary:
+		return percentiles{t.Min, t.Max, t.Sum, t.Count, t.Percentile(0.50), t.Percentile(0.99)}, true
+	case *Sketch:
+		return percentiles{t.Min, t.Max, t.Sum, t.Count, t.Percentile(0.50), t.Percentile(0.99)}, true
+	case *HDRHistogram:
+		return percentiles{
+			float64(t.Min), float64(t.Max), t.Sum, t.Count,
+			float64(t.Percentile(0.50)), float64(t.Percentile(0.99)),
+		}, true
+	}
+	return percentiles{}, false
+}
+
+// Counter returns the counter at key with the labels if it exists.
+func (i *Interval) Counter(key []string, labels ...Label) Counter {
 	m := Counter{}
+	if ValidateKey(key, labels...) != nil {
+		return m
+	}
+	k := canonicalKey(key, labels, kindCounter)
 	v, ok := i.metrics[k]
 	if !ok {
 		return m
 	}
 	c := v.(*Counter)
+	m.Min = c.Min
+	m.Max = c.Max
 	m.Value = c.Value
 	m.Count = c.Count
 	return m
 }
 
-// Gauge returns the gauge at key if it exists.
-func (i *Interval) Gauge(key []string) Gauge {
-	k := keyPath(key) + kindGauge
+// Gauge returns the gauge at key with the labels if it exists.
+func (i *Interval) Gauge(key []string, labels ...Label) Gauge {
 	m := Gauge{}
+	if ValidateKey(key, labels...) != nil {
+		return m
+	}
+	k := canonicalKey(key, labels, kindGauge)
 	v, ok := i.metrics[k]
 	if !ok {
 		return m
@@ -64,10 +318,13 @@ func (i *Interval) Gauge(key []string) Gauge {
 	return m
 }
 
-// Histogram returns the histogram at key if it exists.
-func (i *Interval) Histogram(key []string) Histogram {
-	k := keyPath(key) + kindHistogram
+// Histogram returns the histogram at key with the labels if it exists.
+func (i *Interval) Histogram(key []string, labels ...Label) Histogram {
 	m := Histogram{}
+	if ValidateKey(key, labels...) != nil {
+		return m
+	}
+	k := canonicalKey(key, labels, kindHistogram)
 	v, ok := i.metrics[k]
 	if !ok {
 		return m
@@ -75,6 +332,7 @@ func (i *Interval) Histogram(key []string) Histogram {
 	h := v.(*Histogram)
 	m.Min = h.Min
 	m.Max = h.Max
+	m.Sum = h.Sum
 	m.Count = h.Count
 	m.Dropped = h.Dropped
 	m.Buckets = make([]Bucket, len(h.Buckets))
@@ -82,6 +340,46 @@ func (i *Interval) Histogram(key []string) Histogram {
 	return m
 }
 
+// Summary returns the summary at key with the labels if it exists.
+func (i *Interval) Summary(key []string, labels ...Label) Summary {
+	if ValidateKey(key, labels...) != nil {
+		return Summary{}
+	}
+	k := canonicalKey(key, labels, kindSummary)
+	v, ok := i.metrics[k]
+	if !ok {
+		return Summary{}
+	}
+	return *copySummary(v.(*Summary))
+}
+
+// Sketch returns the sketch at key with the labels if it exists.
+func (i *Interval) Sketch(key []string, labels ...Label) Sketch {
+	if ValidateKey(key, labels...) != nil {
+		return Sketch{}
+	}
+	k := canonicalKey(key, labels, kindSketch)
+	v, ok := i.metrics[k]
+	if !ok {
+		return Sketch{}
+	}
+	return *copySketch(v.(*Sketch))
+}
+
+// HDRHistogram returns the HDR histogram at
+// key with the labels if it exists.
+func (i *Interval) HDRHistogram(key []string, labels ...Label) HDRHistogram {
+	if ValidateKey(key, labels...) != nil {
+		return HDRHistogram{}
+	}
+	k := canonicalKey(key, labels, kindHDR)
+	v, ok := i.metrics[k]
+	if !ok {
+		return HDRHistogram{}
+	}
+	return *copyHDRHistogram(v.(*HDRHistogram))
+}
+
 // MarshalJSON implements the json.Marshaler interface.
 func (i *Interval) MarshalJSON() ([]byte, error) {
 	i.mu.Lock()
@@ -95,35 +393,50 @@ func (i *Interval) MarshalJSON() ([]byte, error) {
 	})
 }
 
-// UnmarshalJSON implements the json.Unmarshaler interface.
+// UnmarshalJSON implements the json.Unmarshaler interface. A *KeyError
+// is returned for keys that are invalid, have a missing or unknown
+// metric kind, or have values that do not match the kind.
+// ErrInvalidTime is returned if the time is missing or invalid.
 func (i *Interval) UnmarshalJSON(b []byte) error {
 	m := struct {
-		Time    int64                      `json:"time"`
+		Time    json.RawMessage            `json:"time"`
 		Metrics map[string]json.RawMessage `json:"metrics"`
 	}{}
 	err := json.Unmarshal(b, &m)
 	if err != nil {
 		return err
 	}
+	t, err := strconv.ParseInt(string(m.Time), 10, 64)
+	if err != nil {
+		return ErrInvalidTime
+	}
 	metrics := make(map[string]any)
 	for k, v := range m.Metrics {
-		n := strings.Index(k, ":")
-		switch k[n:] {
+		err = validateStoredKey(k)
+		if err != nil {
+			return err
+		}
+		_, kind := splitKind(k)
+		switch kind {
 		case kindCounter:
 			metrics[k] = new(Counter)
 		case kindGauge:
 			metrics[k] = new(Gauge)
 		case kindHistogram:
 			metrics[k] = new(Histogram)
-		default:
-			panic(fmt.Errorf("metrics: unexpected metric type '%s'", k[n+1:]))
+		case kindSummary:
+			metrics[k] = new(Summary)
+		case kindSketch:
+			metrics[k] = new(Sketch)
+		case kindHDR:
+			metrics[k] = new(HDRHistogram)
 		}
 		err = json.Unmarshal(v, metrics[k])
 		if err != nil {
-			return err
+			return &KeyError{Key: k, Err: err}
 		}
 	}
-	i.time = time.UnixMilli(m.Time)
+	i.time = time.UnixMilli(t)
 	i.metrics = metrics
 	return nil
 }
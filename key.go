@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Errors returned when validating keys and decoding intervals.
+var (
+	ErrInvalidKey   = errors.New("metrics: invalid key")
+	ErrInvalidLabel = errors.New("metrics: invalid label")
+	ErrMissingKind  = errors.New("metrics: missing metric kind")
+	ErrUnknownKind  = errors.New("metrics: unknown metric kind")
+	ErrInvalidTime  = errors.New("metrics: invalid interval time")
+)
+
+// KeyError records an error and the key that caused it.
+type KeyError struct {
+	Key string
+	Err error
+}
+
+// Error implements the error interface.
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%v '%s'", e.Err, e.Key)
+}
+
+// Unwrap returns the underlying error.
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateKey returns a *KeyError wrapping ErrInvalidKey if the key
+// is empty or any segment is empty, is a dot or dot-dot, contains a
+// control character or any of the reserved characters /:{}, or
+// wrapping ErrInvalidLabel if any label name does not match
+// [a-zA-Z_][a-zA-Z0-9_]* or any label value is not valid UTF-8.
+func ValidateKey(key []string, labels ...Label) error {
+	if len(key) == 0 {
+		return &KeyError{Key: "", Err: ErrInvalidKey}
+	}
+	for _, s := range key {
+		if !validSegment(s) {
+			return &KeyError{Key: strings.Join(key, "/"), Err: ErrInvalidKey}
+		}
+	}
+	for _, l := range labels {
+		if !validLabelName(l.Name) || !utf8.ValidString(l.Value) {
+			return &KeyError{Key: l.Name, Err: ErrInvalidLabel}
+		}
+	}
+	return nil
+}
+
+// validSegment reports whether s is a valid key segment.
+func validSegment(s string) bool {
+	if s == "" || s == "." || s == ".." {
+		return false
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c < 0x20 || c == 0x7f || c == '/' || c == ':' || c == '{' || c == '}' {
+				return false
+			}
+			i++
+			continue
+		}
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && n == 1 || unicode.IsControl(r) {
+			return false
+		}
+		i += n
+	}
+	return true
+}
+
+// keyPath returns a rooted path to the key.
+// The key is expected to have been validated.
+func keyPath(key []string) string {
+	return canonicalKey(key, nil, "")
+}
+
+// validateStoredKey returns a *KeyError if k is not a
+// canonical key as returned by seriesKey with a known kind.
+func validateStoredKey(k string) error {
+	_, kind := splitKind(k)
+	if kind == "" {
+		return &KeyError{Key: k, Err: ErrMissingKind}
+	}
+	if !knownKind(kind) {
+		return &KeyError{Key: k, Err: ErrUnknownKind}
+	}
+	path, _, _, err := parseKey(k)
+	if err != nil {
+		return &KeyError{Key: k, Err: err}
+	}
+	if !strings.HasPrefix(path, "/") {
+		return &KeyError{Key: k, Err: ErrInvalidKey}
+	}
+	for _, s := range strings.Split(path[1:], "/") {
+		if !validSegment(s) {
+			return &KeyError{Key: k, Err: ErrInvalidKey}
+		}
+	}
+	return nil
+}
+
+// knownKind reports whether kind is a known metric kind.
+func knownKind(kind string) bool {
+	switch kind {
+	case kindCounter, kindGauge, kindHistogram, kindSummary, kindSketch, kindHDR:
+		return true
+	}
+	return false
+}
+
+// hasPathPrefix reports whether the key path or canonical key s
+// begins with the key path prefix, matching whole segments such
+// that /a matches /a, /a/b and /a{l="v"}:counter but not /ab.
+func hasPathPrefix(s, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !strings.HasPrefix(s, prefix) {
+		return false
+	}
+	if len(s) == len(prefix) {
+		return true
+	}
+	switch s[len(prefix)] {
+	case '/', '{', ':':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/pnelson/metrics"
+)
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		key    []string
+		labels []metrics.Label
+		err    error
+	}{
+		{[]string{"test"}, nil, nil},
+		{[]string{"http", "requests-total", "2xx"}, []metrics.Label{{Name: "method", Value: "GET"}}, nil},
+		{nil, nil, metrics.ErrInvalidKey},
+		{[]string{}, nil, metrics.ErrInvalidKey},
+		{[]string{""}, nil, metrics.ErrInvalidKey},
+		{[]string{"a", ""}, nil, metrics.ErrInvalidKey},
+		{[]string{".."}, nil, metrics.ErrInvalidKey},
+		{[]string{"a/b"}, nil, metrics.ErrInvalidKey},
+		{[]string{"tenant", "..", "admin"}, nil, metrics.ErrInvalidKey},
+		{[]string{"tenant", "a/../../admin"}, nil, metrics.ErrInvalidKey},
+		{[]string{"a:b"}, nil, metrics.ErrInvalidKey},
+		{[]string{"a{b}"}, nil, metrics.ErrInvalidKey},
+		{[]string{"a\nb"}, nil, metrics.ErrInvalidKey},
+		{[]string{"a\u0085b"}, nil, metrics.ErrInvalidKey},
+		{[]string{"a\xffb"}, nil, metrics.ErrInvalidKey},
+		{[]string{"héllo", "世界"}, nil, nil},
+		{[]string{"test"}, []metrics.Label{{Name: "1a", Value: "b"}}, metrics.ErrInvalidLabel},
+		{[]string{"test"}, []metrics.Label{{Name: "", Value: "b"}}, metrics.ErrInvalidLabel},
+		{[]string{"test"}, []metrics.Label{{Name: "a", Value: "\xff"}}, metrics.ErrInvalidLabel},
+	}
+	for _, tt := range tests {
+		err := metrics.ValidateKey(tt.key, tt.labels...)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("ValidateKey(%q, %v)\nhave %v\nwant %v", tt.key, tt.labels, err, tt.err)
+		}
+		var keyErr *metrics.KeyError
+		if err != nil && !errors.As(err, &keyErr) {
+			t.Fatalf("ValidateKey(%q, %v) should return a *KeyError", tt.key, tt.labels)
+		}
+	}
+}
+
+func TestMetricsErrorHandler(t *testing.T) {
+	var errs []error
+	clock := metrics.NewManualClock(testTime)
+	m := metrics.New(testWindow, testInterval,
+		metrics.WithClock(clock),
+		metrics.WithErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+	key := []string{"test"}
+	m.Add(nil, 1)
+	m.Set([]string{""}, 1)
+	m.Mod([]string{"a:b"}, 1)
+	m.Put(key, 1, metrics.Label{Name: "1", Value: "a"})
+	m.Buckets(nil, nil)
+	m.Add(key, 1)
+	m.Add([]string{"tenant", "a/../../admin"}, 1)
+	if len(errs) != 6 {
+		t.Fatalf("should report invalid keys\nhave %v", errs)
+	}
+	i := &m.Window().Intervals[0]
+	if i.Counter(key).Value != 1 || i.Counter(nil).Count != 0 || i.Counter([]string{"admin"}).Count != 0 {
+		t.Fatalf("should only record valid keys")
+	}
+	if key[0] != "test" {
+		t.Fatalf("should not modify the key\nhave %s", key[0])
+	}
+}
+
+func TestIntervalUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		json string
+		err  error
+	}{
+		{`{"time":1,"metrics":{"/test":{}}}`, metrics.ErrMissingKind},
+		{`{"time":1,"metrics":{"/test:timer":{}}}`, metrics.ErrUnknownKind},
+		{`{"time":1,"metrics":{"/test{a=\"b}:counter":{}}}`, metrics.ErrInvalidLabel},
+		{`{"time":1,"metrics":{"test:counter":{}}}`, metrics.ErrInvalidKey},
+		{`{"time":1,"metrics":{"/a//b:counter":{}}}`, metrics.ErrInvalidKey},
+		{`{"metrics":{}}`, metrics.ErrInvalidTime},
+		{`{"time":"now","metrics":{}}`, metrics.ErrInvalidTime},
+		{`{"time":1.5,"metrics":{}}`, metrics.ErrInvalidTime},
+	}
+	for _, tt := range tests {
+		var i metrics.Interval
+		err := json.Unmarshal([]byte(tt.json), &i)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("json.Unmarshal(%s)\nhave %v\nwant %v", tt.json, err, tt.err)
+		}
+	}
+	var keyErr *metrics.KeyError
+	var i metrics.Interval
+	err := json.Unmarshal([]byte(`{"time":1,"metrics":{"/test:counter":[]}}`), &i)
+	if !errors.As(err, &keyErr) || keyErr.Key != "/test:counter" {
+		t.Fatalf("should return a *KeyError for mismatched values\nhave %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+)
+
+// Label represents a dimension of a metric such as the
+// request method or response status. Metrics with the same
+// key and different labels are aggregated independently.
+type Label struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// seriesKey returns the canonical key for the key path, labels and
+// kind, such as /http/requests{method="GET",status="500"}:counter.
+// Labels are sorted by name. The last value wins for duplicate names.
+// The label names are expected to have been validated.
+func seriesKey(path string, labels []Label, kind string) string {
+	if len(labels) == 0 {
+		return path + kind
+	}
+	return path + labelString(labels) + kind
+}
+
+// canonicalKey returns the canonical key for the key, labels and kind
+// as returned by seriesKey for the key path. The key is built with a
+// single allocation if there are no labels. The key is expected to
+// have been validated.
+func canonicalKey(key []string, labels []Label, kind string) string {
+	var ls string
+	if len(labels) > 0 {
+		ls = labelString(labels)
+	}
+	n := len(key) + len(ls) + len(kind)
+	for _, s := range key {
+		n += len(s)
+	}
+	var b strings.Builder
+	b.Grow(n)
+	for _, s := range key {
+		b.WriteByte('/')
+		b.WriteString(s)
+	}
+	b.WriteString(ls)
+	b.WriteString(kind)
+	return b.String()
+}
+
+// labelString returns the canonical form of the labels.
+func labelString(labels []Label) string {
+	sorted := make([]Label, len(labels))
+	copy(sorted, labels)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range sorted {
+		if i+1 < len(sorted) && sorted[i+1].Name == l.Name {
+			continue
+		}
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(l.Value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// parseKey parses the canonical key into the
+// key path, labels and kind returned by seriesKey.
+func parseKey(k string) (string, []Label, string, error) {
+	path, kind := splitKind(k)
+	n := strings.IndexByte(path, '{')
+	if n < 0 {
+		return path, nil, kind, nil
+	}
+	labels, err := parseLabels(path[n:])
+	if err != nil {
+		return "", nil, "", err
+	}
+	return path[:n], labels, kind, nil
+}
+
+// parseLabels parses the canonical form of the labels.
+func parseLabels(s string) ([]Label, error) {
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return nil, ErrInvalidLabel
+	}
+	s = s[1 : len(s)-1]
+	var labels []Label
+	for len(s) > 0 {
+		n := strings.Index(s, `="`)
+		if n < 0 || !validLabelName(s[:n]) {
+			return nil, ErrInvalidLabel
+		}
+		l := Label{Name: s[:n]}
+		s = s[n+2:]
+		var b strings.Builder
+		for {
+			if len(s) == 0 {
+				return nil, ErrInvalidLabel
+			}
+			c := s[0]
+			s = s[1:]
+			if c == '"' {
+				break
+			}
+			if c == '\\' {
+				if len(s) == 0 {
+					return nil, ErrInvalidLabel
+				}
+				switch s[0] {
+				case '\\', '"':
+					c = s[0]
+				case 'n':
+					c = '\n'
+				default:
+					return nil, ErrInvalidLabel
+				}
+				s = s[1:]
+			}
+			b.WriteByte(c)
+		}
+		l.Value = b.String()
+		labels = append(labels, l)
+		if len(s) > 0 {
+			if s[0] != ',' {
+				return nil, ErrInvalidLabel
+			}
+			s = s[1:]
+		}
+	}
+	return labels, nil
+}
+
+// validLabelName reports whether s matches [a-zA-Z_][a-zA-Z0-9_]*.
+func validLabelName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// labelValueReplacer escapes backslashes, double quotes and newlines.
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue escapes the label value for the canonical form.
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSeriesKey(t *testing.T) {
+	tests := []struct {
+		labels []Label
+		want   string
+	}{
+		{nil, "/test:counter"},
+		{[]Label{{"status", "500"}, {"method", "GET"}}, `/test{method="GET",status="500"}:counter`},
+		{[]Label{{"method", "GET"}, {"method", "POST"}}, `/test{method="POST"}:counter`},
+		{[]Label{{"path", "/a:b\\\"c\"\n"}}, `/test{path="/a:b\\\"c\"\n"}:counter`},
+	}
+	for _, tt := range tests {
+		have := seriesKey("/test", tt.labels, kindCounter)
+		if have != tt.want {
+			t.Fatalf("seriesKey(%v)\nhave %s\nwant %s", tt.labels, have, tt.want)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	labels := []Label{{"method", "GET"}, {"path", "/a:b\\\"c\"\n{}"}}
+	path, have, kind, err := parseKey(seriesKey("/test", labels, kindGauge))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/test" {
+		t.Fatalf("path\nhave %s\nwant %s", path, "/test")
+	}
+	if kind != kindGauge {
+		t.Fatalf("kind\nhave %s\nwant %s", kind, kindGauge)
+	}
+	if !reflect.DeepEqual(have, labels) {
+		t.Fatalf("labels\nhave %v\nwant %v", have, labels)
+	}
+	for _, k := range []string{`/test{:gauge`, `/test{a}:gauge`, `/test{a="b}:gauge`, `/test{a="b"c="d"}:gauge`, `/test{1="b"}:gauge`} {
+		_, _, _, err := parseKey(k)
+		if err == nil {
+			t.Fatalf("parseKey(%s) should error", k)
+		}
+	}
+}
+
+func TestIntervalSeriesInvalidKey(t *testing.T) {
+	i := newInterval(time.Now())
+	i.metrics["/a:counter"] = &Counter{Value: 1}
+	i.metrics[`/b{c}:counter`] = &Counter{Value: 1}
+	i.metrics[`/d{e}:counter`] = &Counter{Value: 1}
+	s, err := i.series()
+	var keyErr *KeyError
+	if !errors.As(err, &keyErr) || keyErr.Key != `/b{c}:counter` || !errors.Is(err, ErrInvalidLabel) {
+		t.Fatalf("series\nhave %v\nwant a *KeyError for the first invalid key", err)
+	}
+	if len(s) != 1 || s[0].key != "/a:counter" {
+		t.Fatalf("series\nhave %v\nwant the valid keys", s)
+	}
+}
@@ -1,250 +1,894 @@
 package metrics
 
 import (
-	"path"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
 	"runtime"
-	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Metrics represents the central manager of metrics activity.
 type Metrics struct {
-	mu        sync.RWMutex
-	window    time.Duration
-	interval  time.Duration
-	buckets   map[string][]Bucket
-	intervals []*Interval
+	mu           sync.RWMutex
+	tick         sync.Mutex
+	clock        Clock
+	errorHandler func(error)
+	window       time.Duration
+	interval     time.Duration
+	buckets      map[string][]Bucket
+	compression  map[string]float64
+	accuracy     map[string]float64
+	digits       map[string]int
+	metadata     map[string]Metadata
+	exporters    []Exporter
+	subscribers  map[chan *Interval]struct{}
+	intervals    []*Interval
+	generation   uint64
+	current      atomic.Value // *Interval
+	shards       int
+	rollups      []*rollup
+	closed       bool
+	done         chan struct{}
+	stopped      chan struct{}
+
+	snapshotFile  string
+	snapshotEvery time.Duration
+	snapshotTime  time.Time
+}
+
+// Option represents a functional option for configuration.
+type Option func(*Metrics)
+
+// WithClock sets the clock used to determine interval times and
+// elapsed durations. Intervals are not advanced automatically
+// when a clock is provided. Use Tick to advance the intervals
+// to the current time of the clock.
+func WithClock(clock Clock) Option {
+	return func(m *Metrics) {
+		m.clock = clock
+	}
+}
+
+// WithErrorHandler sets the handler for errors that cannot be
+// returned to the caller, such as invalid keys passed to the
+// recording methods and errors exporting completed intervals.
+// Errors are logged with the standard logger by default.
+func WithErrorHandler(fn func(error)) Option {
+	return func(m *Metrics) {
+		m.errorHandler = fn
+	}
 }
 
 // New returns a new metrics manager.
-func New(window, interval time.Duration) *Metrics {
+func New(window, interval time.Duration, opts ...Option) *Metrics {
 	m := &Metrics{
-		window:    window,
-		interval:  interval,
-		buckets:   make(map[string][]Bucket),
-		intervals: make([]*Interval, 1, window/interval),
-	}
-	t := time.Now().Truncate(interval).Add(interval)
-	m.intervals[0] = newInterval(t)
-	// Force create every interval.
+		window:      window,
+		interval:    interval,
+		buckets:     make(map[string][]Bucket),
+		compression: make(map[string]float64),
+		accuracy:    make(map[string]float64),
+		digits:      make(map[string]int),
+		metadata:    make(map[string]Metadata),
+		subscribers: make(map[chan *Interval]struct{}),
+		intervals:   make([]*Interval, 1, window/interval),
+		shards:      histogramShards(),
+		done:        make(chan struct{}),
+	}
+	for _, option := range opts {
+		option(m)
+	}
+	if m.errorHandler == nil {
+		m.errorHandler = func(err error) { log.Println(err) }
+	}
+	manual := m.clock != nil
+	if !manual {
+		m.clock = realClock{}
+	}
+	now := m.clock.Now()
+	t := now.Truncate(interval).Add(interval)
+	m.intervals[0] = newLiveInterval(t, m.shards)
+	m.current.Store(m.intervals[0])
+	if m.snapshotFile != "" {
+		m.snapshotTime = now
+		err := m.LoadFile(m.snapshotFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			m.errorHandler(err)
+		}
+	}
+	if manual {
+		return m
+	}
+	m.stopped = make(chan struct{})
+	go m.run()
+	return m
+}
+
+// exportBuffer is the number of completed intervals queued for the
+// exporters when the intervals are advanced in the background.
+const exportBuffer = 16
+
+// errExportDropped is reported when the exporters fall behind the
+// intervals advanced in the background.
+var errExportDropped = errors.New("metrics: exporters fell behind, dropped the oldest completed interval")
+
+// run forces the creation of every interval until closed. The
+// completed intervals are exported on a separate goroutine such
+// that slow exporters do not delay the intervals advancing.
+func (m *Metrics) run() {
+	defer close(m.stopped)
+	queue := make(chan *Interval, exportBuffer)
+	exported := make(chan struct{})
 	go func() {
-		for {
-			select {
-			case <-time.After(interval):
-				t = t.Add(interval)
-				i := newInterval(t)
-				m.mu.Lock()
-				if len(m.intervals) == cap(m.intervals) {
-					copy(m.intervals, m.intervals[1:])
-					m.intervals[len(m.intervals)-1] = i
-				} else {
-					m.intervals = append(m.intervals, i)
-				}
-				m.mu.Unlock()
-			}
+		defer close(exported)
+		for i := range queue {
+			m.exportInterval(i)
 		}
 	}()
-	return m
+	defer func() {
+		close(queue)
+		<-exported
+	}()
+	for {
+		m.mu.RLock()
+		t := m.intervals[len(m.intervals)-1].time
+		m.mu.RUnlock()
+		timer := time.NewTimer(t.Sub(m.clock.Now()))
+		select {
+		case <-timer.C:
+			m.tick.Lock()
+			completed := m.advance()
+			m.tick.Unlock()
+			for _, i := range completed {
+				m.enqueue(queue, i)
+			}
+		case <-m.done:
+			timer.Stop()
+			return
+		}
+	}
 }
 
-// Add adds value to key.
-func (m *Metrics) Add(key []string, value float64) {
-	k := keyPath(key) + kindCounter
+// enqueue queues i for the exporters, dropping and reporting the
+// oldest queued interval if the queue is full.
+func (m *Metrics) enqueue(queue chan *Interval, i *Interval) {
+	for {
+		select {
+		case queue <- i:
+			return
+		default:
+		}
+		select {
+		case <-queue:
+			m.errorHandler(errExportDropped)
+		default:
+		}
+	}
+}
+
+// Exporter represents a destination for completed intervals.
+type Exporter interface {
+	Export(i *Interval) error
+}
+
+// Flusher is implemented by exporters that buffer
+// exported intervals to be flushed on Close.
+type Flusher interface {
+	Flush() error
+}
+
+// Export registers e to export each completed interval.
+func (m *Metrics) Export(e Exporter) {
+	m.mu.Lock()
+	m.exporters = append(m.exporters, e)
+	m.mu.Unlock()
+}
+
+// Tick advances the intervals to the current time of the clock.
+// Each interval that has elapsed since the last tick is created,
+// up to the capacity of the window, and the completed intervals
+// are exported before Tick returns. Tick does nothing after Close.
+func (m *Metrics) Tick() {
+	m.tick.Lock()
+	defer m.tick.Unlock()
+	for _, i := range m.advance() {
+		m.exportInterval(i)
+	}
+}
+
+// advance advances the intervals to the current time of the clock,
+// publishes the completed intervals to the subscribers and saves the
+// snapshot file if due. Snapshots of the completed intervals are
+// returned to be exported. The caller must hold m.tick.
+func (m *Metrics) advance() []*Interval {
+	now := m.clock.Now()
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	t := m.intervals[len(m.intervals)-1].time
+	if now.Before(t) {
+		m.mu.Unlock()
+		return nil
+	}
+	n := int(now.Sub(t)/m.interval) + 1
+	if n > cap(m.intervals) {
+		t = t.Add(time.Duration(n-cap(m.intervals)) * m.interval)
+		n = cap(m.intervals)
+	}
+	completed := []*Interval{m.intervals[len(m.intervals)-1]}
+	var errs []error
+	for ; n > 0; n-- {
+		t = t.Add(m.interval)
+		errs = append(errs, m.rotate(newLiveInterval(t, m.shards))...)
+		if n > 1 {
+			completed = append(completed, m.intervals[len(m.intervals)-1])
+		}
+	}
+	m.mu.Unlock()
+	for _, err := range errs {
+		m.errorHandler(err)
+	}
+	for n, i := range completed {
+		completed[n] = i.snapshot()
+		m.publish(completed[n])
+	}
+	if m.snapshotFile != "" && now.Sub(m.snapshotTime) >= m.snapshotEvery {
+		m.snapshotTime = now
+		err := m.SaveFile(m.snapshotFile)
+		if err != nil {
+			m.errorHandler(err)
+		}
+	}
+	return completed
+}
+
+// exportInterval exports the completed interval i to the exporters
+// and reports the first error encountered.
+func (m *Metrics) exportInterval(i *Interval) {
+	m.mu.RLock()
+	exporters := m.exporters
+	m.mu.RUnlock()
+	err := m.export(exporters, i)
+	if err != nil {
+		m.errorHandler(err)
+	}
+}
+
+// export exports i to each of the exporters
+// and returns the first error encountered.
+func (m *Metrics) export(exporters []Exporter, i *Interval) error {
+	var err error
+	for _, e := range exporters {
+		if exportErr := e.Export(i); exportErr != nil && err == nil {
+			err = exportErr
+		}
+	}
+	return err
+}
+
+// Close stops advancing the intervals, exports the current interval
+// as completed, flushes the exporters and saves the snapshot file, if
+// any. Values recorded after Close are not exported. The channels of
+// the subscriptions are closed after receiving the current interval.
+// Close returns the first error encountered.
+func (m *Metrics) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	close(m.done)
+	m.mu.Unlock()
+	if m.stopped != nil {
+		<-m.stopped
+	}
+	m.tick.Lock()
+	defer m.tick.Unlock()
 	m.mu.RLock()
-	defer m.mu.RUnlock()
 	i := m.intervals[len(m.intervals)-1]
-	i.mu.Lock()
-	defer i.mu.Unlock()
-	v, ok := i.metrics[k]
+	exporters := m.exporters
+	m.mu.RUnlock()
+	snapshot := i.snapshot()
+	err := m.export(exporters, snapshot)
+	m.publish(snapshot)
+	m.mu.Lock()
+	for ch := range m.subscribers {
+		delete(m.subscribers, ch)
+		close(ch)
+	}
+	m.mu.Unlock()
+	for _, e := range exporters {
+		f, ok := e.(Flusher)
+		if !ok {
+			continue
+		}
+		if flushErr := f.Flush(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+	if m.snapshotFile != "" {
+		if saveErr := m.SaveFile(m.snapshotFile); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}
+	return err
+}
+
+// rotate appends i as the current interval, discarding the
+// oldest interval if the window is at capacity. The errors rolling
+// up the oldest interval are returned. The caller must hold m.mu.
+func (m *Metrics) rotate(i *Interval) []error {
+	var errs []error
+	if len(m.intervals) == cap(m.intervals) {
+		evicted := m.intervals[0]
+		copy(m.intervals, m.intervals[1:])
+		m.intervals[len(m.intervals)-1] = i
+		errs = m.rollup(0, evicted, m.interval)
+	} else {
+		m.intervals = append(m.intervals, i)
+	}
+	m.current.Store(i)
+	m.freeze()
+	m.generation++
+	return errs
+}
+
+// freeze freezes the intervals before the current interval. Writers
+// record while holding the read lock of the interval, so freezing
+// waits for the writers that loaded the interval before it was
+// rotated, and later writers retry at the current interval. The
+// caller must hold m.mu.
+func (m *Metrics) freeze() {
+	for n := len(m.intervals) - 2; n >= 0; n-- {
+		if m.intervals[n].live() {
+			m.intervals[n].freeze()
+		}
+	}
+}
+
+// Add adds value to key with the optional labels.
+func (m *Metrics) Add(key []string, value float64, labels ...Label) {
+	k, ok := m.seriesKey(key, labels, kindCounter)
 	if !ok {
-		i.metrics[k] = NewCounter(value)
 		return
 	}
-	v.(*Counter).Add(value)
+	m.add(k, value)
 }
 
-// Set sets key to value.
-func (m *Metrics) Set(key []string, value float64) {
-	k := keyPath(key) + kindGauge
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	i := m.intervals[len(m.intervals)-1]
-	i.mu.Lock()
-	defer i.mu.Unlock()
-	v, ok := i.metrics[k]
+// add adds value to the counter at the canonical key k.
+func (m *Metrics) add(k string, value float64) {
+	i, v := m.acquire(k, newCounterMetric)
+	m.addCell(i, k, v, value)
+}
+
+// addCell adds value to the counter cell v at the canonical key k
+// within interval i and releases the read lock of i.
+func (m *Metrics) addCell(i *Interval, k string, v any, value float64) {
+	defer i.mu.RUnlock()
+	c, ok := v.(*counterCell)
 	if !ok {
-		i.metrics[k] = NewGauge(value)
+		m.errorHandler(unexpectedMetricError(k, v))
 		return
 	}
-	v.(*Gauge).Set(value)
+	c.add(value)
 }
 
-// Mod modifies key by relative value. Use this for gauges that increment
-// or decrement instead of maintaining a persistent value. The gauge will
-// increment or decrement from zero if there is no previous value within
-// the entire stored window.
-func (m *Metrics) Mod(key []string, value float64) {
-	k := keyPath(key) + kindGauge
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	i := m.intervals[len(m.intervals)-1]
-	i.mu.Lock()
-	defer i.mu.Unlock()
-	v, ok := i.metrics[k]
+// acquire returns the current interval, read locked, and the cell at
+// the canonical key k within it, storing the cell returned by newCell
+// if the key does not exist. The interval is not frozen until the
+// caller releases the read lock, so a value recorded to the cell
+// before releasing the lock is never lost to a concurrent Tick. An
+// interval frozen before the lock was acquired is retried at the
+// current interval, which Tick stores before freezing. newCell is
+// called without holding the lock of the interval.
+func (m *Metrics) acquire(k string, newCell func(m *Metrics, k string, i *Interval) any) (*Interval, any) {
+	for {
+		i := m.current.Load().(*Interval)
+		i.mu.RLock()
+		if i.frozen {
+			i.mu.RUnlock()
+			continue
+		}
+		if v, ok := i.metrics[k]; ok {
+			return i, v
+		}
+		i.mu.RUnlock()
+		c := newCell(m, k, i)
+		i.mu.Lock()
+		if _, ok := i.metrics[k]; !ok && !i.frozen {
+			i.metrics[k] = c
+		}
+		i.mu.Unlock()
+	}
+}
+
+// unexpectedMetricError returns an error reporting that the metric at
+// the canonical key k is not a cell of the kind of the key.
+func unexpectedMetricError(k string, v any) error {
+	return fmt.Errorf("metrics: unexpected %T for %s", v, k)
+}
+
+// newCounterMetric returns a new counter cell.
+func newCounterMetric(m *Metrics, k string, i *Interval) any {
+	return newCounterCell()
+}
+
+// Set sets key with the optional labels to value.
+func (m *Metrics) Set(key []string, value float64, labels ...Label) {
+	k, ok := m.seriesKey(key, labels, kindGauge)
 	if !ok {
-		prev := m.prevGaugeValue(k)
-		i.metrics[k] = NewGauge(prev + value)
 		return
 	}
-	g := v.(*Gauge)
-	g.Set(g.Value + value)
+	m.set(k, value)
+}
+
+// set sets the gauge at the canonical key k to value.
+func (m *Metrics) set(k string, value float64) {
+	i, v := m.acquire(k, newGaugeMetric)
+	m.setCell(i, k, v, value)
 }
 
-func (m *Metrics) prevGaugeValue(key string) float64 {
-	if len(m.intervals) < 2 {
-		return 0
+// setCell sets the gauge cell v at the canonical key k within
+// interval i to value and releases the read lock of i.
+func (m *Metrics) setCell(i *Interval, k string, v any, value float64) {
+	defer i.mu.RUnlock()
+	g, ok := v.(*gaugeCell)
+	if !ok {
+		m.errorHandler(unexpectedMetricError(k, v))
+		return
 	}
-	for n := len(m.intervals) - 2; n >= 0; n-- {
+	g.set(value)
+}
+
+// newGaugeMetric returns a new gauge cell.
+func newGaugeMetric(m *Metrics, k string, i *Interval) any {
+	return newGaugeCell()
+}
+
+// Mod modifies key with the optional labels by relative value. Use
+// this for gauges that increment or decrement instead of maintaining
+// a persistent value. The gauge will increment or decrement from zero
+// if there is no previous value within the entire stored window.
+func (m *Metrics) Mod(key []string, value float64, labels ...Label) {
+	k, ok := m.seriesKey(key, labels, kindGauge)
+	if !ok {
+		return
+	}
+	m.mod(k, value)
+}
+
+// mod modifies the gauge at the canonical key k by relative value.
+func (m *Metrics) mod(k string, value float64) {
+	i, v, stored := m.acquireGauge(k, value)
+	if stored {
+		i.mu.RUnlock()
+		return
+	}
+	m.modCell(i, k, v, value)
+}
+
+// acquireGauge is like acquire for the gauge at the canonical key k.
+// A new gauge is set to value relative to the last value of the gauge
+// within the intervals before the current interval. The result is
+// true if the gauge was stored, such that value has been applied.
+func (m *Metrics) acquireGauge(k string, value float64) (*Interval, any, bool) {
+	var stored *gaugeCell
+	i, v := m.acquire(k, func(m *Metrics, k string, i *Interval) any {
+		stored = newGaugeCell()
+		m.mu.RLock()
+		stored.set(m.prevGaugeValue(k, i.time) + value)
+		m.mu.RUnlock()
+		return stored
+	})
+	return i, v, v == any(stored)
+}
+
+// modCell modifies the gauge cell v at the canonical key k within
+// interval i by value and releases the read lock of i.
+func (m *Metrics) modCell(i *Interval, k string, v any, value float64) {
+	defer i.mu.RUnlock()
+	g, ok := v.(*gaugeCell)
+	if !ok {
+		m.errorHandler(unexpectedMetricError(k, v))
+		return
+	}
+	g.mod(value)
+}
+
+// prevGaugeValue returns the last value of the gauge at the canonical
+// key k within the intervals before t, or zero if there is none.
+// The caller must hold m.mu.
+func (m *Metrics) prevGaugeValue(k string, t time.Time) float64 {
+	for n := len(m.intervals) - 1; n >= 0; n-- {
 		i := m.intervals[n]
-		i.mu.RLock()
-		v, ok := i.metrics[key]
+		if !i.time.Before(t) {
+			continue
+		}
+		v, ok := i.load(k)
 		if !ok {
-			i.mu.RUnlock()
 			continue
 		}
-		prev := v.(*Gauge).Value
-		i.mu.RUnlock()
-		return prev
+		if c, ok := v.(cell); ok {
+			v = c.metric()
+		}
+		return v.(*Gauge).Value
 	}
 	return 0
 }
 
-// Put adds value as a sample for key.
-func (m *Metrics) Put(key []string, value float64) {
-	k := keyPath(key) + kindHistogram
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	i := m.intervals[len(m.intervals)-1]
-	i.mu.Lock()
-	defer i.mu.Unlock()
-	v, ok := i.metrics[k]
+// Put adds value as a sample for key with the optional labels.
+func (m *Metrics) Put(key []string, value float64, labels ...Label) {
+	k, ok := m.seriesKey(key, labels, kindHistogram)
 	if !ok {
-		buckets := m.bucketsFor(k)
-		v := NewHistogram(buckets)
-		v.Put(value)
-		i.metrics[k] = v
 		return
 	}
-	v.(*Histogram).Put(value)
+	m.put(k, value, 1)
 }
 
-// Timer adds the elapsed duration in milliseconds as a sample for key.
-func (m *Metrics) Timer(key []string, t time.Time) {
-	ms := time.Since(t).Milliseconds()
-	m.Put(key, float64(ms))
+// put adds value as n samples for the histogram at the canonical key k.
+func (m *Metrics) put(k string, value float64, n uint64) {
+	i, v := m.acquire(k, newHistogramMetric)
+	m.putCell(i, k, v, value, n)
+}
+
+// putCell adds value as n samples to the histogram cell v at the
+// canonical key k within interval i and releases the read lock of i.
+func (m *Metrics) putCell(i *Interval, k string, v any, value float64, n uint64) {
+	defer i.mu.RUnlock()
+	h, ok := v.(*histogramCell)
+	if !ok {
+		m.errorHandler(unexpectedMetricError(k, v))
+		return
+	}
+	h.putN(value, n)
+}
+
+// newHistogramMetric returns a new histogram cell with the shards of
+// interval i and the buckets configured for the canonical key k.
+func newHistogramMetric(m *Metrics, k string, i *Interval) any {
+	m.mu.RLock()
+	layout := m.bucketsFor(k)
+	m.mu.RUnlock()
+	return newHistogramCell(layout, i.shards)
+}
+
+// seriesKey returns the canonical key for the key, labels and kind.
+// Invalid keys are reported to the error handler.
+func (m *Metrics) seriesKey(key []string, labels []Label, kind string) (string, bool) {
+	err := ValidateKey(key, labels...)
+	if err != nil {
+		m.errorHandler(err)
+		return "", false
+	}
+	return canonicalKey(key, labels, kind), true
+}
+
+// Timer adds the elapsed duration in milliseconds as
+// a sample for key with the optional labels.
+func (m *Metrics) Timer(key []string, t time.Time, labels ...Label) {
+	ms := m.clock.Now().Sub(t).Milliseconds()
+	m.Put(key, float64(ms), labels...)
 }
 
 // Buckets sets the initial buckets for histograms at the key prefix.
 func (m *Metrics) Buckets(key []string, buckets []Bucket) {
+	err := ValidateKey(key)
+	if err != nil {
+		m.errorHandler(err)
+		return
+	}
 	k := keyPath(key)
 	m.mu.Lock()
 	m.buckets[k] = buckets
 	m.mu.Unlock()
 }
 
-// bucketsFor returns the histogram buckets using
-// a longest prefix match from the configured buckets.
+// bucketsFor returns a copy of the histogram buckets using a longest
+// prefix match from the configured buckets. The caller must hold m.mu.
 func (m *Metrics) bucketsFor(s string) []Bucket {
+	buckets, ok := longestPrefix(m.buckets, s)
+	if !ok {
+		return NewDefaultLatencyBuckets()
+	}
+	v := make([]Bucket, len(buckets))
+	for i, b := range buckets {
+		v[i] = Bucket{Value: b.Value}
+	}
+	return v
+}
+
+// Observe adds value as a sample for the summary at
+// key with the optional labels.
+func (m *Metrics) Observe(key []string, value float64, labels ...Label) {
+	k, ok := m.seriesKey(key, labels, kindSummary)
+	if !ok {
+		return
+	}
+	m.observe(k, value)
+}
+
+// observe adds value as a sample for the summary at the canonical
+// key k. The summary is created with the configured compression.
+func (m *Metrics) observe(k string, value float64) {
+	i, v := m.acquire(k, newSummaryMetric)
+	defer i.mu.RUnlock()
+	s, ok := v.(*summaryCell)
+	if !ok {
+		m.errorHandler(unexpectedMetricError(k, v))
+		return
+	}
+	s.put(value)
+}
+
+// newSummaryMetric returns a new summary cell with the shards of
+// interval i and the compression configured for the canonical key k.
+func newSummaryMetric(m *Metrics, k string, i *Interval) any {
+	m.mu.RLock()
+	compression := m.compressionFor(k)
+	m.mu.RUnlock()
+	return newSummaryCell(compression, i.shards)
+}
+
+// Compression sets the compression for summaries at the key prefix.
+// Higher compression improves the accuracy of the percentiles at
+// the cost of memory. The default is DefaultCompression.
+func (m *Metrics) Compression(key []string, compression float64) {
+	err := ValidateKey(key)
+	if err != nil {
+		m.errorHandler(err)
+		return
+	}
+	k := keyPath(key)
+	m.mu.Lock()
+	m.compression[k] = compression
+	m.mu.Unlock()
+}
+
+// compressionFor returns the summary compression using a longest prefix
+// match from the configured compression. The caller must hold m.mu.
+func (m *Metrics) compressionFor(s string) float64 {
+	compression, ok := longestPrefix(m.compression, s)
+	if !ok || !(compression > 0) {
+		return DefaultCompression
+	}
+	return compression
+}
+
+// Sample adds value as a sample for the sketch at
+// key with the optional labels.
+func (m *Metrics) Sample(key []string, value float64, labels ...Label) {
+	k, ok := m.seriesKey(key, labels, kindSketch)
+	if !ok {
+		return
+	}
+	m.sample(k, value)
+}
+
+// sample adds value as a sample for the sketch at the canonical
+// key k. The sketch is created with the configured accuracy.
+func (m *Metrics) sample(k string, value float64) {
+	i, v := m.acquire(k, newSketchMetric)
+	defer i.mu.RUnlock()
+	s, ok := v.(*sketchCell)
+	if !ok {
+		m.errorHandler(unexpectedMetricError(k, v))
+		return
+	}
+	s.put(value)
+}
+
+// newSketchMetric returns a new sketch cell with the shards of
+// interval i and the accuracy configured for the canonical key k.
+func newSketchMetric(m *Metrics, k string, i *Interval) any {
+	m.mu.RLock()
+	accuracy := m.accuracyFor(k)
+	m.mu.RUnlock()
+	return newSketchCell(accuracy, i.shards)
+}
+
+// Accuracy sets the relative accuracy for sketches at the key prefix,
+// between 0 and 1. Higher accuracy requires more bins for the same
+// range of values. The default is DefaultAccuracy.
+func (m *Metrics) Accuracy(key []string, accuracy float64) {
+	err := ValidateKey(key)
+	if err != nil {
+		m.errorHandler(err)
+		return
+	}
+	k := keyPath(key)
+	m.mu.Lock()
+	m.accuracy[k] = accuracy
+	m.mu.Unlock()
+}
+
+// accuracyFor returns the sketch accuracy using a longest prefix
+// match from the configured accuracy. The caller must hold m.mu.
+func (m *Metrics) accuracyFor(s string) float64 {
+	accuracy, ok := longestPrefix(m.accuracy, s)
+	if !ok || !(accuracy > 0 && accuracy < 1) {
+		return DefaultAccuracy
+	}
+	return accuracy
+}
+
+// Record adds value as a sample for the HDR histogram
+// at key with the optional labels.
+func (m *Metrics) Record(key []string, value int64, labels ...Label) {
+	m.RecordCorrected(key, value, 0, labels...)
+}
+
+// RecordCorrected adds value as a sample for the HDR histogram at
+// key with the optional labels, correcting for coordinated omission
+// when a sample is expected every expectedInterval, such as a load
+// generator issuing requests at a fixed rate. See
+// HDRHistogram.PutCorrected.
+func (m *Metrics) RecordCorrected(key []string, value, expectedInterval int64, labels ...Label) {
+	k, ok := m.seriesKey(key, labels, kindHDR)
+	if !ok {
+		return
+	}
+	m.record(k, value, expectedInterval)
+}
+
+// record adds value as a sample for the HDR histogram at the
+// canonical key k. The histogram is created with the configured
+// significant digits.
+func (m *Metrics) record(k string, value, expectedInterval int64) {
+	i, v := m.acquire(k, newHDRMetric)
+	defer i.mu.RUnlock()
+	h, ok := v.(*hdrCell)
+	if !ok {
+		m.errorHandler(unexpectedMetricError(k, v))
+		return
+	}
+	h.put(value, expectedInterval)
+}
+
+// newHDRMetric returns a new HDR histogram cell with the shards of
+// interval i and the significant digits configured for the canonical key k.
+func newHDRMetric(m *Metrics, k string, i *Interval) any {
+	m.mu.RLock()
+	digits := m.digitsFor(k)
+	m.mu.RUnlock()
+	return newHDRCell(digits, i.shards)
+}
+
+// SignificantDigits sets the significant decimal digits for HDR
+// histograms at the key prefix, between 1 and 5. Each digit
+// multiplies the number of buckets by about ten. The default
+// is DefaultSignificantDigits.
+func (m *Metrics) SignificantDigits(key []string, digits int) {
+	err := ValidateKey(key)
+	if err != nil {
+		m.errorHandler(err)
+		return
+	}
+	k := keyPath(key)
+	m.mu.Lock()
+	m.digits[k] = digits
+	m.mu.Unlock()
+}
+
+// digitsFor returns the HDR histogram digits using a longest prefix
+// match from the configured digits. The caller must hold m.mu.
+func (m *Metrics) digitsFor(s string) int {
+	digits, ok := longestPrefix(m.digits, s)
+	if !ok || digits < 1 || digits > 5 {
+		return DefaultSignificantDigits
+	}
+	return digits
+}
+
+// Metadata describes the metrics at a key prefix.
+type Metadata struct {
+	Help string // description of the metric
+	Unit string // unit of the metric such as seconds or bytes
+}
+
+// Metadata sets the metadata for metrics at the key prefix.
+func (m *Metrics) Metadata(key []string, md Metadata) {
+	err := ValidateKey(key)
+	if err != nil {
+		m.errorHandler(err)
+		return
+	}
+	k := keyPath(key)
+	m.mu.Lock()
+	m.metadata[k] = md
+	m.mu.Unlock()
+}
+
+// metadataFor returns the metadata using a longest
+// prefix match from the configured metadata.
+func (m *Metrics) metadataFor(s string) Metadata {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	if len(m.buckets) == 0 {
-		return NewDefaultLatencyBuckets()
+	md, _ := longestPrefix(m.metadata, s)
+	return md
+}
+
+// longestPrefix returns the value in values with the longest
+// key path that is a prefix of whole segments of s.
+func longestPrefix[T any](values map[string]T, s string) (T, bool) {
+	var v T
+	if len(values) == 0 {
+		return v, false
 	}
-	keys := make([]string, 0, len(m.buckets))
-	for k := range m.buckets {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-	longestPrefix := ""
-	for _, k := range keys {
-		n := 0
-		for i := range k {
-			if i >= len(s) || k[i] != s[i] {
-				break
-			}
-			n++
-		}
-		if n > len(longestPrefix) {
-			longestPrefix = k
+	longest := ""
+	for k := range values {
+		if len(k) > len(longest) && hasPathPrefix(s, k) {
+			longest = k
 		}
 	}
-	if longestPrefix == "" {
-		return NewDefaultLatencyBuckets()
+	if longest == "" {
+		return v, false
 	}
-	return m.buckets[longestPrefix]
+	return values[longest], true
 }
 
-// MemStats records runtime memory allocator metric values at interval d.
+// MemStats records runtime memory allocator metric values at
+// interval d until the metrics manager is closed.
 func (m *Metrics) MemStats(d time.Duration) {
+	m.MemStatsContext(context.Background(), d)
+}
+
+// MemStatsContext records runtime memory allocator metric values at
+// interval d until ctx is done or the metrics manager is closed.
+func (m *Metrics) MemStatsContext(ctx context.Context, d time.Duration) {
 	var stats runtime.MemStats
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-time.After(d):
+		case <-ticker.C:
 			runtime.ReadMemStats(&stats)
 			m.Set([]string{"memstats", "goroutines"}, float64(runtime.NumGoroutine())) // count
 			m.Set([]string{"memstats", "total"}, float64(stats.Sys))                   // bytes
 			m.Set([]string{"memstats", "alloc"}, float64(stats.Alloc))                 // bytes
 			m.Set([]string{"memstats", "count"}, float64(stats.HeapObjects))           // count
+		case <-ctx.Done():
+			return
+		case <-m.done:
+			return
 		}
 	}
 }
 
-// Window returns a copy of the windowed intervals.
+// Window returns a copy of the windowed intervals
+// at the finest resolution.
 func (m *Metrics) Window() Window {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	return newWindow(m.window, m.intervals)
+}
+
+// newWindow returns a copy of the intervals as a window.
+func newWindow(window time.Duration, intervals []*Interval) Window {
 	view := Window{
-		Duration:  int(m.window.Seconds()),
-		Intervals: make([]Interval, len(m.intervals)),
-	}
-	for n, i := range m.intervals {
-		metrics := make(map[string]any)
-		// The current interval needs a read lock.
-		// The defer won't run until the method returns
-		// but it only runs on the last iteration.
-		if n == len(m.intervals)-1 {
-			i.mu.RLock()
-			defer i.mu.RUnlock()
-		}
-		for k, v := range i.metrics {
-			switch t := v.(type) {
-			case *Counter:
-				c := new(Counter)
-				*c = *t
-				metrics[k] = c
-			case *Gauge:
-				g := new(Gauge)
-				*g = *t
-				metrics[k] = g
-			case *Histogram:
-				h := new(Histogram)
-				*h = *t
-				metrics[k] = h
-			default:
-				panic("metrics: unexpected metric type")
-			}
-		}
-		view.Intervals[n] = Interval{time: i.time, metrics: metrics}
+		Duration:  int(window.Seconds()),
+		Intervals: make([]Interval, len(intervals)),
+	}
+	for n, i := range intervals {
+		view.Intervals[n] = Interval{time: i.time, metrics: i.copyMetrics()}
 	}
 	return view
 }
 
-// keyPath returns a rooted path to the key.
-func keyPath(key []string) string {
-	if len(key) == 0 {
-		panic("metrics: key required")
+// last returns a copy of the last completed interval,
+// or the current interval if no interval has completed.
+func (m *Metrics) last() *Interval {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i := m.intervals[len(m.intervals)-1]
+	if len(m.intervals) > 1 {
+		i = m.intervals[len(m.intervals)-2]
 	}
-	key[0] = "/" + key[0]
-	return path.Join(key...)
+	return i.snapshot()
 }
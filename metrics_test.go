@@ -1,8 +1,10 @@
 package metrics_test
 
 import (
+	"context"
 	"encoding/json"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,10 +14,18 @@ import (
 var (
 	testWindow   = 100 * time.Millisecond
 	testInterval = 10 * time.Millisecond
+	testTime     = time.Date(2022, time.January, 1, 0, 0, 0, 0, time.Local)
 )
 
+// newTestMetrics returns a new metrics manager
+// with intervals advanced by a manual clock.
+func newTestMetrics() (*metrics.Metrics, *metrics.ManualClock) {
+	clock := metrics.NewManualClock(testTime)
+	return metrics.New(testWindow, testInterval, metrics.WithClock(clock)), clock
+}
+
 func TestMetrics(t *testing.T) {
-	m := metrics.New(testWindow, testInterval)
+	m, _ := newTestMetrics()
 	m.Add([]string{"c"}, 1)
 	m.Set([]string{"g"}, 1)
 	m.Put([]string{"s"}, 1)
@@ -26,7 +36,7 @@ func TestMetrics(t *testing.T) {
 }
 
 func TestMetricsAdd(t *testing.T) {
-	m := metrics.New(testWindow, testInterval)
+	m, _ := newTestMetrics()
 	m.Add([]string{"test"}, 1)
 	m.Add([]string{"test"}, 2)
 	m.Add([]string{"test"}, 3)
@@ -36,7 +46,7 @@ func TestMetricsAdd(t *testing.T) {
 	if len(w.Intervals) != 1 {
 		t.Fatalf("should only return the current interval")
 	}
-	i := w.Intervals[0]
+	i := &w.Intervals[0]
 	c := i.Counter([]string{"test"})
 	if c.Value != 19.0 {
 		t.Fatalf("Value\nhave %f\nwant %f", c.Value, 19.0)
@@ -53,7 +63,7 @@ func TestMetricsAdd(t *testing.T) {
 }
 
 func TestMetricsSet(t *testing.T) {
-	m := metrics.New(testWindow, testInterval)
+	m, _ := newTestMetrics()
 	m.Set([]string{"test"}, 1)
 	m.Set([]string{"test"}, 5)
 	m.Set([]string{"test"}, 2)
@@ -63,7 +73,7 @@ func TestMetricsSet(t *testing.T) {
 	if len(w.Intervals) != 1 {
 		t.Fatalf("should only return the current interval")
 	}
-	i := w.Intervals[0]
+	i := &w.Intervals[0]
 	g := i.Gauge([]string{"test"})
 	if g.Min != 1.0 {
 		t.Fatalf("Min\nhave %f\nwant %f", g.Min, 1.0)
@@ -86,7 +96,7 @@ func TestMetricsSet(t *testing.T) {
 }
 
 func TestMetricsMod(t *testing.T) {
-	m := metrics.New(testWindow, testInterval)
+	m, clock := newTestMetrics()
 	m.Mod([]string{"test"}, 3)
 	m.Mod([]string{"test"}, 2)
 	m.Mod([]string{"test"}, -1)
@@ -94,18 +104,19 @@ func TestMetricsMod(t *testing.T) {
 	if len(w.Intervals) != 1 {
 		t.Fatalf("should only return the current interval")
 	}
-	i := w.Intervals[0]
+	i := &w.Intervals[0]
 	g := i.Gauge([]string{"test"})
 	if g.Value != 4.0 {
 		t.Fatalf("Value\nhave %f\nwant %f", g.Value, 4.0)
 	}
-	<-time.After(3 * testInterval)
+	clock.Advance(3 * testInterval)
+	m.Tick()
 	m.Mod([]string{"test"}, 3)
 	w = m.Window()
-	if len(w.Intervals) < 2 {
+	if len(w.Intervals) != 4 {
 		t.Fatalf("should have several intervals")
 	}
-	i = w.Intervals[len(w.Intervals)-1]
+	i = &w.Intervals[len(w.Intervals)-1]
 	g = i.Gauge([]string{"test"})
 	if g.Min != 7.0 {
 		t.Fatalf("Min\nhave %f\nwant %f", g.Min, 7.0)
@@ -122,7 +133,7 @@ func TestMetricsMod(t *testing.T) {
 }
 
 func TestMetricsPut(t *testing.T) {
-	m := metrics.New(testWindow, testInterval)
+	m, _ := newTestMetrics()
 	m.Put([]string{"test"}, 1)
 	m.Put([]string{"test"}, 2)
 	m.Put([]string{"test"}, 3)
@@ -134,7 +145,7 @@ func TestMetricsPut(t *testing.T) {
 	if len(w.Intervals) != 1 {
 		t.Fatalf("should only return the current interval")
 	}
-	i := w.Intervals[0]
+	i := &w.Intervals[0]
 	h := i.Histogram([]string{"test"})
 	if h.Min != 1.0 {
 		t.Fatalf("Min\nhave %f\nwant %f", h.Min, 1.0)
@@ -169,7 +180,7 @@ func TestMetricsPut(t *testing.T) {
 }
 
 func TestMetricsBuckets(t *testing.T) {
-	m := metrics.New(testWindow, testInterval)
+	m, _ := newTestMetrics()
 	m.Buckets([]string{"test"}, metrics.NewLinearBuckets(1, 1, 3))
 	m.Put([]string{"test"}, 1)
 	m.Put([]string{"test"}, 2)
@@ -180,7 +191,7 @@ func TestMetricsBuckets(t *testing.T) {
 	if len(w.Intervals) != 1 {
 		t.Fatalf("should only return the current interval")
 	}
-	i := w.Intervals[0]
+	i := &w.Intervals[0]
 	h := i.Histogram([]string{"test"})
 	if h.Count != 5 {
 		t.Fatalf("Count\nhave %d\nwant %d", h.Count, 5)
@@ -191,21 +202,11 @@ func TestMetricsBuckets(t *testing.T) {
 }
 
 func TestMetricsWindow(t *testing.T) {
-	m := metrics.New(testWindow, testInterval)
-	done := make(chan struct{})
-	go func() {
-		for {
-			select {
-			case c := <-time.After(time.Millisecond): // 10x per interval
-				m.Set([]string{"test"}, float64(c.UnixMilli()))
-			case <-done:
-				return
-			}
-		}
-	}()
-	select {
-	case <-time.After(testWindow + 3*testInterval):
-		close(done)
+	m, clock := newTestMetrics()
+	for n := 0; n < int((testWindow+3*testInterval)/time.Millisecond); n++ {
+		clock.Advance(time.Millisecond) // 10x per interval
+		m.Tick()
+		m.Set([]string{"test"}, float64(clock.Now().UnixMilli()))
 	}
 	w := m.Window()
 	if len(w.Intervals) != int(testWindow/testInterval) {
@@ -214,14 +215,64 @@ func TestMetricsWindow(t *testing.T) {
 	for i := 1; i < len(w.Intervals)-1; i++ {
 		v1 := w.Intervals[i-1].Gauge([]string{"test"}).Value
 		v2 := w.Intervals[i].Gauge([]string{"test"}).Value
-		if v1 == 0 || v2 < v1 {
+		if v1 == 0 || v2 != v1+float64(testInterval/time.Millisecond) {
 			t.Fatalf("should have increasing values i=%d\nv1=%d\nv2=%d", i, uint64(v1), uint64(v2))
 		}
 	}
 }
 
-func TestMetricsWindowMarshal(t *testing.T) {
+func TestMetricsTick(t *testing.T) {
+	m, clock := newTestMetrics()
+	m.Tick()
+	if n := len(m.Window().Intervals); n != 1 {
+		t.Fatalf("should not advance before the interval elapses\nhave %d\nwant %d", n, 1)
+	}
+	clock.Advance(testInterval - 1)
+	m.Tick()
+	if n := len(m.Window().Intervals); n != 1 {
+		t.Fatalf("should not advance before the interval elapses\nhave %d\nwant %d", n, 1)
+	}
+	clock.Advance(1)
+	m.Tick()
+	w := m.Window()
+	if n := len(w.Intervals); n != 2 {
+		t.Fatalf("should advance when the interval elapses\nhave %d\nwant %d", n, 2)
+	}
+	if have, want := w.Intervals[1].Time(), testTime.Add(2*testInterval); !have.Equal(want) {
+		t.Fatalf("Time\nhave %v\nwant %v", have, want)
+	}
+	clock.Advance(time.Hour)
+	m.Tick()
+	w = m.Window()
+	if n := len(w.Intervals); n != int(testWindow/testInterval) {
+		t.Fatalf("should cap to window\nhave %d\nwant %d", n, int(testWindow/testInterval))
+	}
+	if have, want := w.Intervals[len(w.Intervals)-1].Time(), clock.Now().Add(testInterval); !have.Equal(want) {
+		t.Fatalf("Time\nhave %v\nwant %v", have, want)
+	}
+}
+
+func TestMetricsTimer(t *testing.T) {
+	m, clock := newTestMetrics()
+	start := clock.Now()
+	clock.Advance(5 * time.Millisecond)
+	m.Timer([]string{"test"}, start)
+	h := m.Window().Intervals[0].Histogram([]string{"test"})
+	if h.Sum != 5 {
+		t.Fatalf("Sum\nhave %f\nwant %f", h.Sum, 5.0)
+	}
+}
+
+func TestMetricsRealClock(t *testing.T) {
 	m := metrics.New(testWindow, testInterval)
+	<-time.After(3 * testInterval)
+	if n := len(m.Window().Intervals); n < 2 {
+		t.Fatalf("should advance intervals in the background")
+	}
+}
+
+func TestMetricsWindowMarshal(t *testing.T) {
+	m, _ := newTestMetrics()
 	m.Set([]string{"test"}, 1)
 	want := m.Window()
 	b, err := json.Marshal(want)
@@ -237,3 +288,255 @@ func TestMetricsWindowMarshal(t *testing.T) {
 		t.Fatalf("json\nhave %v\nwant %v", have, want)
 	}
 }
+
+type testExporter struct {
+	intervals []*metrics.Interval
+	flushed   bool
+}
+
+func (e *testExporter) Export(i *metrics.Interval) error {
+	e.intervals = append(e.intervals, i)
+	return nil
+}
+
+func (e *testExporter) Flush() error {
+	e.flushed = true
+	return nil
+}
+
+func TestMetricsExport(t *testing.T) {
+	m, clock := newTestMetrics()
+	e := &testExporter{}
+	m.Export(e)
+	m.Add([]string{"test"}, 1)
+	clock.Advance(testInterval)
+	m.Tick()
+	m.Add([]string{"test"}, 2)
+	clock.Advance(3 * testInterval)
+	m.Tick()
+	if len(e.intervals) != 4 {
+		t.Fatalf("should export every completed interval\nhave %d\nwant %d", len(e.intervals), 4)
+	}
+	for n, want := range []float64{1, 2, 0, 0} {
+		have := e.intervals[n].Counter([]string{"test"}).Value
+		if have != want {
+			t.Fatalf("Value[%d]\nhave %f\nwant %f", n, have, want)
+		}
+	}
+	if e.flushed {
+		t.Fatalf("should not flush before close")
+	}
+}
+
+// blockingExporter blocks exporting until the channel is closed.
+type blockingExporter chan struct{}
+
+func (e blockingExporter) Export(i *metrics.Interval) error {
+	<-e
+	return nil
+}
+
+func TestMetricsExportRealClock(t *testing.T) {
+	m := metrics.New(testWindow, testInterval, metrics.WithErrorHandler(func(err error) {}))
+	e := make(blockingExporter)
+	m.Export(e)
+	deadline := time.Now().Add(time.Second)
+	for len(m.Window().Intervals) < 4 {
+		if time.Now().After(deadline) {
+			close(e)
+			t.Fatalf("should advance the intervals while exporting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(e)
+	err := m.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMetricsClose(t *testing.T) {
+	m, clock := newTestMetrics()
+	e := &testExporter{}
+	m.Export(e)
+	m.Add([]string{"test"}, 1)
+	err := m.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(e.intervals) != 1 || e.intervals[0].Counter([]string{"test"}).Value != 1 {
+		t.Fatalf("should export the current interval")
+	}
+	if !e.flushed {
+		t.Fatalf("should flush the exporters")
+	}
+	clock.Advance(testInterval)
+	m.Tick()
+	if len(e.intervals) != 1 {
+		t.Fatalf("should not advance after close")
+	}
+	err = m.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMetricsCloseRealClock(t *testing.T) {
+	m := metrics.New(testWindow, testInterval)
+	done := make(chan struct{})
+	go func() {
+		m.MemStatsContext(context.Background(), testInterval)
+		close(done)
+	}()
+	err := m.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n := len(m.Window().Intervals)
+	<-done
+	<-time.After(2 * testInterval)
+	if len(m.Window().Intervals) != n {
+		t.Fatalf("should not advance after close")
+	}
+}
+
+func TestMetricsLabels(t *testing.T) {
+	m, _ := newTestMetrics()
+	get := metrics.Label{Name: "method", Value: "GET"}
+	post := metrics.Label{Name: "method", Value: "POST"}
+	status := metrics.Label{Name: "status", Value: "500"}
+	m.Add([]string{"test"}, 1, get, status)
+	m.Add([]string{"test"}, 2, status, get)
+	m.Add([]string{"test"}, 4, post)
+	m.Add([]string{"test"}, 8)
+	w := m.Window()
+	i := &w.Intervals[0]
+	tests := []struct {
+		labels []metrics.Label
+		want   float64
+	}{
+		{[]metrics.Label{get, status}, 3},
+		{[]metrics.Label{post}, 4},
+		{nil, 8},
+		{[]metrics.Label{get}, 0},
+	}
+	for _, tt := range tests {
+		have := i.Counter([]string{"test"}, tt.labels...).Value
+		if have != tt.want {
+			t.Fatalf("Counter(%v)\nhave %f\nwant %f", tt.labels, have, tt.want)
+		}
+	}
+	b, err := json.Marshal(w)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	have := metrics.Window{}
+	err = json.Unmarshal(b, &have)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(have, w) {
+		t.Fatalf("json\nhave %v\nwant %v", have, w)
+	}
+}
+
+func TestMetricsConcurrent(t *testing.T) {
+	m, _ := newTestMetrics()
+	c := m.CounterHandle([]string{"handle", "counter"})
+	h := m.HistogramHandle([]string{"handle", "histogram"})
+	const goroutines = 8
+	const n = 1000
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := 1; v <= n; v++ {
+				m.Add([]string{"counter"}, 1)
+				m.Mod([]string{"gauge"}, 1)
+				m.Put([]string{"histogram"}, float64(v))
+				c.Add(2)
+				h.Put(float64(v))
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.Window()
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-stopped
+	w := m.Window()
+	i := &w.Intervals[0]
+	want := metrics.Counter{Min: 1, Max: 1, Value: goroutines * n, Count: goroutines * n}
+	if have := i.Counter([]string{"counter"}); have != want {
+		t.Fatalf("Counter\nhave %v\nwant %v", have, want)
+	}
+	want = metrics.Counter{Min: 2, Max: 2, Value: 2 * goroutines * n, Count: goroutines * n}
+	if have := i.Counter([]string{"handle", "counter"}); have != want {
+		t.Fatalf("CounterHandle\nhave %v\nwant %v", have, want)
+	}
+	g := i.Gauge([]string{"gauge"})
+	if g.Value != goroutines*n || g.Max != goroutines*n || g.Count != goroutines*n {
+		t.Fatalf("Gauge\nhave %v\nwant value, max and count %d", g, goroutines*n)
+	}
+	for _, key := range [][]string{{"histogram"}, {"handle", "histogram"}} {
+		s := i.Histogram(key)
+		if s.Min != 1 || s.Max != n || s.Sum != goroutines*n*(n+1)/2 || s.Count != goroutines*n {
+			t.Fatalf("Histogram(%v)\nhave min %f max %f sum %f count %d", key, s.Min, s.Max, s.Sum, s.Count)
+		}
+		count := s.Dropped
+		for _, b := range s.Buckets {
+			count += b.Count
+		}
+		if count != s.Count {
+			t.Fatalf("Histogram(%v) bucket counts\nhave %d\nwant %d", key, count, s.Count)
+		}
+	}
+}
+
+func TestMetricsConcurrentTick(t *testing.T) {
+	clock := metrics.NewManualClock(testTime)
+	m := metrics.New(time.Hour, testInterval, metrics.WithClock(clock))
+	c := m.CounterHandle([]string{"handle"})
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := 0; v < 1000; v++ {
+				m.Add([]string{"counter"}, 1)
+				m.Set([]string{"gauge"}, float64(v))
+				m.Put([]string{"histogram"}, float64(v))
+				c.Add(1)
+			}
+		}()
+	}
+	for n := 0; n < 20; n++ {
+		clock.Advance(time.Duration(n%3+1) * testInterval)
+		m.Tick()
+		m.Window()
+	}
+	wg.Wait()
+	var counter, handle float64
+	var histogram uint64
+	w := m.Window()
+	for n := range w.Intervals {
+		counter += w.Intervals[n].Counter([]string{"counter"}).Value
+		handle += w.Intervals[n].Counter([]string{"handle"}).Value
+		histogram += w.Intervals[n].Histogram([]string{"histogram"}).Count
+	}
+	if counter != 8000 || handle != 8000 || histogram != 8000 {
+		t.Fatalf("totals\nhave counter %f, handle %f and histogram %d\nwant 8000", counter, handle, histogram)
+	}
+}
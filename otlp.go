@@ -0,0 +1,358 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTLP exporter defaults.
+const (
+	otlpContentType = "application/json"
+	otlpScope       = "github.com/pnelson/metrics"
+	otlpTimeout     = 10 * time.Second
+)
+
+// otlpDelta is the delta aggregation temporality.
+const otlpDelta = 1
+
+// OTLPExporter exports completed intervals to an OpenTelemetry
+// collector as OTLP/HTTP JSON. Key paths are sent as dotted metric
+// names suffixed with the metric kind, such as http.requests.counter,
+// and labels as data point attributes. Each data point spans from the
+// start to the end of the interval.
+//
+// Counters are sent as delta sums of the value, gauges as gauges of
+// the last value and histograms as delta explicit bucket histograms.
+// The bucket values are the explicit bounds, each bucket counting
+// the samples up to and including the bound. Samples of a -Inf bucket
+// are counted in the first bucket and dropped samples in the overflow
+// bucket. Summaries, sketches and HDR
+// histograms are sent as summaries of the minimum, 50th and 99th
+// percentiles and maximum.
+type OTLPExporter struct {
+	url      string
+	interval time.Duration
+	resource []otlpAttribute
+	header   http.Header
+	client   *http.Client
+}
+
+// OTLPOption represents a functional option for
+// configuring the OTLP exporter.
+type OTLPOption func(*OTLPExporter)
+
+// WithOTLPResource adds the labels as attributes of the resource,
+// such as a service.name attribute identifying the service.
+func WithOTLPResource(labels ...Label) OTLPOption {
+	return func(e *OTLPExporter) {
+		for _, l := range labels {
+			e.resource = append(e.resource, newOTLPAttribute(l))
+		}
+	}
+}
+
+// WithOTLPHeader sets a header sent with each request,
+// such as an Authorization header.
+func WithOTLPHeader(name, value string) OTLPOption {
+	return func(e *OTLPExporter) {
+		e.header.Set(name, value)
+	}
+}
+
+// WithOTLPClient sets the HTTP client used to send requests.
+// The default client times out after 10 seconds.
+func WithOTLPClient(client *http.Client) OTLPOption {
+	return func(e *OTLPExporter) {
+		e.client = client
+	}
+}
+
+// NewOTLPExporter returns a new OTLP exporter sending to the metrics
+// endpoint url, such as http://localhost:4318/v1/metrics. The interval
+// is the duration of the exported intervals.
+func NewOTLPExporter(url string, interval time.Duration, opts ...OTLPOption) *OTLPExporter {
+	e := &OTLPExporter{
+		url:      url,
+		interval: interval,
+		header:   make(http.Header),
+		client:   &http.Client{Timeout: otlpTimeout},
+	}
+	for _, option := range opts {
+		option(e)
+	}
+	return e
+}
+
+// Export implements the Exporter interface. An error is returned
+// if the request could not be sent or was not accepted, or a
+// *KeyError if a key of the interval could not be parsed.
+func (e *OTLPExporter) Export(i *Interval) error {
+	metrics, skipped := e.metrics(i)
+	if len(metrics) == 0 {
+		return skipped
+	}
+	body, err := json.Marshal(otlpRequest{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource: otlpResource{Attributes: e.resource},
+			ScopeMetrics: []otlpScopeMetrics{{
+				Scope:   otlpInstrumentationScope{Name: otlpScope},
+				Metrics: metrics,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range e.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", otlpContentType)
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = responseError("otlp export", resp)
+	if err != nil {
+		return err
+	}
+	return skipped
+}
+
+// metrics returns the metrics of the interval with a data point
+// for each series. Counters and gauges that are not finite are
+// omitted, along with metrics left without data points. A *KeyError
+// is returned if a key of the interval could not be parsed.
+func (e *OTLPExporter) metrics(i *Interval) ([]otlpMetric, error) {
+	end := i.Time()
+	start := otlpTime(end.Add(-e.interval))
+	t := otlpTime(end)
+	var metrics []otlpMetric
+	var prev series
+	all, skipped := i.series()
+	for _, s := range all {
+		if len(metrics) == 0 || s.path != prev.path || s.kind != prev.kind {
+			m := otlpMetric{Name: otlpName(s.path, s.kind)}
+			switch s.kind {
+			case kindCounter:
+				m.Sum = &otlpSum{AggregationTemporality: otlpDelta, IsMonotonic: true}
+			case kindGauge:
+				m.Gauge = &otlpGauge{}
+			case kindHistogram:
+				m.Histogram = &otlpHistogram{AggregationTemporality: otlpDelta}
+			case kindSummary, kindSketch, kindHDR:
+				m.Summary = &otlpSummary{}
+			default:
+				continue
+			}
+			metrics = append(metrics, m)
+			prev = s
+		}
+		m := &metrics[len(metrics)-1]
+		var attributes []otlpAttribute
+		for _, l := range s.labels {
+			attributes = append(attributes, newOTLPAttribute(l))
+		}
+		switch v := s.value.(type) {
+		case *Counter:
+			if !finite(v.Value) {
+				continue
+			}
+			if v.Min < 0 {
+				m.Sum.IsMonotonic = false
+			}
+			m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberDataPoint{
+				Attributes:        attributes,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      t,
+				AsDouble:          v.Value,
+			})
+		case *Gauge:
+			if !finite(v.Value) {
+				continue
+			}
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpNumberDataPoint{
+				Attributes:        attributes,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      t,
+				AsDouble:          v.Value,
+			})
+		case *Histogram:
+			p := otlpHistogramDataPoint{
+				Attributes:        attributes,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      t,
+				Count:             strconv.FormatUint(v.Count, 10),
+				BucketCounts:      make([]string, 0, len(v.Buckets)+1),
+				ExplicitBounds:    make([]float64, 0, len(v.Buckets)),
+			}
+			underflow, overflow := uint64(0), v.Dropped
+			for _, b := range v.Buckets {
+				switch {
+				case math.IsInf(b.Value, -1):
+					underflow += b.Count
+					continue
+				case !finite(b.Value):
+					overflow += b.Count
+					continue
+				}
+				p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(underflow+b.Count, 10))
+				p.ExplicitBounds = append(p.ExplicitBounds, b.Value)
+				underflow = 0
+			}
+			overflow += underflow
+			p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(overflow, 10))
+			if v.Count > 0 && finite(v.Sum) && finite(v.Min) && finite(v.Max) {
+				sum, lo, hi := v.Sum, v.Min, v.Max
+				p.Sum, p.Min, p.Max = &sum, &lo, &hi
+			}
+			m.Histogram.DataPoints = append(m.Histogram.DataPoints, p)
+		default:
+			q, ok := percentilesOf(v)
+			if !ok || !finite(q.sum) {
+				continue
+			}
+			p := otlpSummaryDataPoint{
+				Attributes:        attributes,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      t,
+				Count:             strconv.FormatUint(q.count, 10),
+				Sum:               q.sum,
+			}
+			if q.count > 0 {
+				for _, value := range []otlpValueAtQuantile{{0, q.min}, {0.5, q.p50}, {0.99, q.p99}, {1, q.max}} {
+					if finite(value.Value) {
+						p.QuantileValues = append(p.QuantileValues, value)
+					}
+				}
+			}
+			m.Summary.DataPoints = append(m.Summary.DataPoints, p)
+		}
+	}
+	n := 0
+	for _, m := range metrics {
+		if m.Histogram != nil || (m.Sum != nil && len(m.Sum.DataPoints) > 0) || (m.Gauge != nil && len(m.Gauge.DataPoints) > 0) || (m.Summary != nil && len(m.Summary.DataPoints) > 0) {
+			metrics[n] = m
+			n++
+		}
+	}
+	return metrics[:n], skipped
+}
+
+// otlpName returns the metric name of the key path and kind,
+// such as http.requests.counter for /http/requests:counter.
+func otlpName(path, kind string) string {
+	return strings.ReplaceAll(strings.TrimPrefix(path, "/"), "/", ".") + "." + strings.TrimPrefix(kind, ":")
+}
+
+// otlpTime returns t as the decimal string of
+// the nanoseconds since the Unix epoch.
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otlpRequest represents an ExportMetricsServiceRequest.
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpInstrumentationScope `json:"scope"`
+	Metrics []otlpMetric             `json:"metrics"`
+}
+
+type otlpInstrumentationScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name      string         `json:"name"`
+	Sum       *otlpSum       `json:"sum,omitempty"`
+	Gauge     *otlpGauge     `json:"gauge,omitempty"`
+	Histogram *otlpHistogram `json:"histogram,omitempty"`
+	Summary   *otlpSummary   `json:"summary,omitempty"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                      `json:"aggregationTemporality"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryDataPoint `json:"dataPoints"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               *float64        `json:"sum,omitempty"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+	Min               *float64        `json:"min,omitempty"`
+	Max               *float64        `json:"max,omitempty"`
+}
+
+type otlpSummaryDataPoint struct {
+	Attributes        []otlpAttribute       `json:"attributes,omitempty"`
+	StartTimeUnixNano string                `json:"startTimeUnixNano"`
+	TimeUnixNano      string                `json:"timeUnixNano"`
+	Count             string                `json:"count"`
+	Sum               float64               `json:"sum"`
+	QuantileValues    []otlpValueAtQuantile `json:"quantileValues,omitempty"`
+}
+
+type otlpValueAtQuantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+type otlpAttribute struct {
+	Key   string             `json:"key"`
+	Value otlpAttributeValue `json:"value"`
+}
+
+type otlpAttributeValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// newOTLPAttribute returns the label as a string attribute.
+func newOTLPAttribute(l Label) otlpAttribute {
+	return otlpAttribute{Key: l.Name, Value: otlpAttributeValue{StringValue: l.Value}}
+}
@@ -0,0 +1,149 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pnelson/metrics"
+)
+
+// serveOTLP returns a local stand-in for an OpenTelemetry collector
+// and a channel receiving the requests decoded from the JSON body.
+func serveOTLP(t *testing.T) (*httptest.Server, chan any) {
+	t.Helper()
+	requests := make(chan any, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var v any
+		err := json.NewDecoder(r.Body).Decode(&v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests <- v
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "{}")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+func TestOTLPExporter(t *testing.T) {
+	srv, requests := serveOTLP(t)
+	e := metrics.NewOTLPExporter(srv.URL+"/v1/metrics", testInterval,
+		metrics.WithOTLPResource(metrics.Label{Name: "service.name", Value: "api"}),
+		metrics.WithOTLPHeader("Authorization", "Bearer secret"),
+	)
+	m, clock := newTestMetrics()
+	m.Export(e)
+	m.Add([]string{"http", "requests"}, 3, metrics.Label{Name: "method", Value: "GET"})
+	m.Set([]string{"http", "active"}, 2)
+	m.Buckets([]string{"http", "latency"}, []metrics.Bucket{{Value: 10}, {Value: 20}})
+	m.Put([]string{"http", "latency"}, 5)
+	m.Put([]string{"http", "latency"}, 15)
+	m.Put([]string{"http", "latency"}, 30)
+	m.Add([]string{"queue"}, 1)
+	m.Set([]string{"queue"}, 4)
+	m.Record([]string{"db"}, 100)
+	clock.Advance(testInterval)
+	m.Tick()
+	var have any
+	select {
+	case have = <-requests:
+	default:
+		t.Fatalf("should export the completed interval")
+	}
+	start := strconv.FormatInt(testTime.UnixNano(), 10)
+	end := strconv.FormatInt(testTime.Add(testInterval).UnixNano(), 10)
+	times := `"startTimeUnixNano":"` + start + `","timeUnixNano":"` + end + `"`
+	var want any
+	err := json.Unmarshal([]byte(`{"resourceMetrics":[{
+		"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"api"}}]},
+		"scopeMetrics":[{"scope":{"name":"github.com/pnelson/metrics"},"metrics":[
+			{"name":"db.hdr","summary":{"dataPoints":[{`+times+`,"count":"1","sum":100,"quantileValues":[
+				{"quantile":0,"value":100},{"quantile":0.5,"value":100},{"quantile":0.99,"value":100},{"quantile":1,"value":100}]}]}},
+			{"name":"http.active.gauge","gauge":{"dataPoints":[{`+times+`,"asDouble":2}]}},
+			{"name":"http.latency.histogram","histogram":{"aggregationTemporality":1,"dataPoints":[{`+times+`,
+				"count":"3","sum":50,"min":5,"max":30,"bucketCounts":["1","1","1"],"explicitBounds":[10,20]}]}},
+			{"name":"http.requests.counter","sum":{"aggregationTemporality":1,"isMonotonic":true,"dataPoints":[{
+				"attributes":[{"key":"method","value":{"stringValue":"GET"}}],`+times+`,"asDouble":3}]}},
+			{"name":"queue.counter","sum":{"aggregationTemporality":1,"isMonotonic":true,"dataPoints":[{`+times+`,"asDouble":1}]}},
+			{"name":"queue.gauge","gauge":{"dataPoints":[{`+times+`,"asDouble":4}]}}
+		]}]
+	}]}`), &want)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(have, want) {
+		haveJSON, _ := json.Marshal(have)
+		wantJSON, _ := json.Marshal(want)
+		t.Fatalf("request\nhave %s\nwant %s", haveJSON, wantJSON)
+	}
+}
+
+func TestOTLPExporterInfiniteBuckets(t *testing.T) {
+	srv, requests := serveOTLP(t)
+	m, clock := newTestMetrics()
+	m.Export(metrics.NewOTLPExporter(srv.URL+"/v1/metrics", testInterval,
+		metrics.WithOTLPHeader("Authorization", "Bearer secret"),
+	))
+	m.Buckets([]string{"h"}, []metrics.Bucket{{Value: math.Inf(-1)}, {Value: 10}, {Value: math.Inf(1)}})
+	m.Put([]string{"h"}, math.Inf(-1))
+	m.Put([]string{"h"}, 5)
+	m.Put([]string{"h"}, 50)
+	clock.Advance(testInterval)
+	m.Tick()
+	var have any
+	select {
+	case have = <-requests:
+	default:
+		t.Fatalf("should export the completed interval")
+	}
+	b, _ := json.Marshal(have)
+	var req struct {
+		ResourceMetrics []struct {
+			ScopeMetrics []struct {
+				Metrics []struct {
+					Histogram struct {
+						DataPoints []struct {
+							BucketCounts   []string  `json:"bucketCounts"`
+							ExplicitBounds []float64 `json:"explicitBounds"`
+						} `json:"dataPoints"`
+					} `json:"histogram"`
+				} `json:"metrics"`
+			} `json:"scopeMetrics"`
+		} `json:"resourceMetrics"`
+	}
+	err := json.Unmarshal(b, &req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := req.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Histogram.DataPoints[0]
+	if !reflect.DeepEqual(p.BucketCounts, []string{"2", "1"}) || !reflect.DeepEqual(p.ExplicitBounds, []float64{10}) {
+		t.Fatalf("buckets\nhave %v %v\nwant [2 1] [10]", p.BucketCounts, p.ExplicitBounds)
+	}
+}
+
+func TestOTLPExporterError(t *testing.T) {
+	srv, _ := serveOTLP(t)
+	e := metrics.NewOTLPExporter(srv.URL+"/v1/metrics", testInterval)
+	i := decodeInterval(t, `{"time":1000,"metrics":{"/a:counter":{"min":1,"max":1,"value":1,"count":1}}}`)
+	err := e.Export(i)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("Export\nhave %v\nwant the response status", err)
+	}
+}
@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// snapshot represents the persisted windows at every resolution.
+type snapshot struct {
+	Resolutions []resolution `json:"resolutions"`
+}
+
+// resolution represents a persisted window at an interval.
+type resolution struct {
+	Interval int64  `json:"interval"` // milliseconds
+	Window   Window `json:"window"`
+}
+
+// WithSnapshot restores the windows from the file name, if it exists,
+// and saves the windows to the file every d as intervals advance and
+// on Close. The file is replaced atomically.
+func WithSnapshot(name string, d time.Duration) Option {
+	return func(m *Metrics) {
+		m.snapshotFile = name
+		m.snapshotEvery = d
+	}
+}
+
+// Snapshot writes the windows at every resolution to w.
+func (m *Metrics) Snapshot(w io.Writer) error {
+	m.mu.RLock()
+	s := snapshot{Resolutions: make([]resolution, 0, len(m.rollups)+1)}
+	s.Resolutions = append(s.Resolutions, resolution{
+		Interval: m.interval.Milliseconds(),
+		Window:   newWindow(m.window, m.intervals),
+	})
+	for _, r := range m.rollups {
+		s.Resolutions = append(s.Resolutions, resolution{
+			Interval: r.interval.Milliseconds(),
+			Window:   newWindow(r.window, r.intervals),
+		})
+	}
+	m.mu.RUnlock()
+	return json.NewEncoder(w).Encode(s)
+}
+
+// Restore merges the windows written by Snapshot into the windows
+// at the matching resolutions. Restored intervals are aligned to the
+// current intervals. Intervals after the current interval are
+// discarded. Intervals before the window are merged into the next
+// coarser resolution, if any, or discarded. The first error merging
+// a restored interval is returned after restoring the remaining
+// intervals.
+func (m *Metrics) Restore(r io.Reader) error {
+	var s snapshot
+	err := json.NewDecoder(r).Decode(&s)
+	if err != nil {
+		return err
+	}
+	restored := make(map[time.Duration][]Interval)
+	for _, r := range s.Resolutions {
+		restored[time.Duration(r.Interval)*time.Millisecond] = r.Window.Intervals
+	}
+	m.mu.Lock()
+	var errs []error
+	latest := m.intervals[len(m.intervals)-1].time
+	for n := len(m.rollups) - 1; n >= 0; n-- {
+		r := m.rollups[n]
+		t := latest.Add(-m.interval).Truncate(r.interval).Add(r.interval)
+		var stale []*Interval
+		var ringErr error
+		r.intervals, stale, ringErr = restoreRing(r.intervals, restored[r.interval], r.interval, t, 0)
+		if ringErr != nil && err == nil {
+			err = ringErr
+		}
+		for _, i := range stale {
+			errs = append(errs, m.rollup(n+1, i, r.interval)...)
+		}
+	}
+	var stale []*Interval
+	var ringErr error
+	m.intervals, stale, ringErr = restoreRing(m.intervals, restored[m.interval], m.interval, latest, m.shards)
+	if ringErr != nil && err == nil {
+		err = ringErr
+	}
+	m.current.Store(m.intervals[len(m.intervals)-1])
+	m.freeze()
+	m.generation++
+	for _, i := range stale {
+		errs = append(errs, m.rollup(0, i, m.interval)...)
+	}
+	m.mu.Unlock()
+	for _, err := range errs {
+		m.errorHandler(err)
+	}
+	return err
+}
+
+// restoreRing merges the restored intervals into the ring of intervals
+// of duration d ending at latest and returns the new ring. The restored
+// intervals before the capacity of the ring are returned in time order.
+// Intervals added to the ring are live with n histogram shards if n is
+// greater than zero. The first error merging a restored interval is
+// returned after merging the remaining intervals.
+func restoreRing(ring []*Interval, restored []Interval, d time.Duration, latest time.Time, n int) ([]*Interval, []*Interval, error) {
+	earliest := latest.Add(-time.Duration(cap(ring)) * d)
+	slots := make(map[time.Time]*Interval, cap(ring))
+	var stale []*Interval
+	for _, i := range ring {
+		if !i.time.After(earliest) {
+			stale = append(stale, i)
+			continue
+		}
+		slots[i.time] = i
+	}
+	sort.SliceStable(restored, func(a, b int) bool {
+		return restored[a].time.Before(restored[b].time)
+	})
+	var err error
+	for r := range restored {
+		i := &restored[r]
+		t := i.time.Add(-1).Truncate(d).Add(d)
+		if t.After(latest) {
+			continue
+		}
+		if !t.After(earliest) {
+			stale = append(stale, &Interval{time: t, metrics: i.metrics})
+			continue
+		}
+		dst, ok := slots[t]
+		if !ok {
+			dst = newLiveInterval(t, n)
+			slots[t] = dst
+		}
+		mergeErr := dst.merge(i)
+		if mergeErr != nil && err == nil {
+			err = mergeErr
+		}
+	}
+	sort.Slice(stale, func(a, b int) bool {
+		return stale[a].time.Before(stale[b].time)
+	})
+	if len(slots) == 0 {
+		return ring[:0], stale, err
+	}
+	var first, last time.Time
+	for t := range slots {
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if last.IsZero() || t.After(last) {
+			last = t
+		}
+	}
+	intervals := make([]*Interval, 0, cap(ring))
+	for t := first; !t.After(last); t = t.Add(d) {
+		i, ok := slots[t]
+		if !ok {
+			i = newLiveInterval(t, n)
+		}
+		intervals = append(intervals, i)
+	}
+	return intervals, stale, err
+}
+
+// SaveFile saves the windows at every resolution to the file name.
+// The file is written to a temporary file in the same directory and
+// renamed to replace the file atomically.
+func (m *Metrics) SaveFile(name string) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = m.Snapshot(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// LoadFile restores the windows saved to the file name. The error
+// will wrap fs.ErrNotExist if the file does not exist.
+func (m *Metrics) LoadFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.Restore(f)
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestoreRingShards(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	restored := func() []Interval {
+		return []Interval{
+			{time: now.Add(-time.Second), metrics: map[string]any{"/a:counter": &Counter{Value: 1, Count: 1}}},
+			{time: now, metrics: map[string]any{"/a:counter": &Counter{Value: 2, Count: 1}}},
+		}
+	}
+	intervals, _, err := restoreRing(make([]*Interval, 0, 4), restored(), time.Second, now, 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, i := range intervals {
+		if i.shards != 4 {
+			t.Fatalf("shards\nhave %d\nwant %d", i.shards, 4)
+		}
+	}
+	intervals, _, err = restoreRing(make([]*Interval, 0, 4), restored(), time.Second, now, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, i := range intervals {
+		if _, ok := i.metrics["/a:counter"].(*Counter); !ok || i.live() {
+			t.Fatalf("should restore plain metrics without shards\nhave %T", i.metrics["/a:counter"])
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package metrics_test
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pnelson/metrics"
+)
+
+func newTestSnapshot(t *testing.T) *bytes.Buffer {
+	m, clock := newTestMetrics()
+	for n := 1; n <= 3; n++ {
+		if n > 1 {
+			clock.Advance(testInterval)
+			m.Tick()
+		}
+		m.Add([]string{"test"}, float64(n))
+		m.Put([]string{"test"}, float64(n))
+	}
+	var b bytes.Buffer
+	err := m.Snapshot(&b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return &b
+}
+
+func TestMetricsRestore(t *testing.T) {
+	b := newTestSnapshot(t)
+	clock := metrics.NewManualClock(testTime.Add(4 * testInterval))
+	m := metrics.New(testWindow, testInterval, metrics.WithClock(clock))
+	m.Add([]string{"test"}, 5)
+	err := m.Restore(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w := m.Window()
+	if len(w.Intervals) != 5 {
+		t.Fatalf("should align restored intervals\nhave %d\nwant %d", len(w.Intervals), 5)
+	}
+	for n, want := range []float64{1, 2, 3, 0, 5} {
+		i := &w.Intervals[n]
+		if have := i.Time(); !have.Equal(testTime.Add(testInterval * time.Duration(n+1))) {
+			t.Fatalf("Time[%d]\nhave %v", n, have)
+		}
+		if have := i.Counter([]string{"test"}).Value; have != want {
+			t.Fatalf("Value[%d]\nhave %f\nwant %f", n, have, want)
+		}
+		if have := i.Histogram([]string{"test"}).Sum; n < 3 && have != want {
+			t.Fatalf("Sum[%d]\nhave %f\nwant %f", n, have, want)
+		}
+	}
+	clock.Advance(testInterval)
+	m.Tick()
+	if n := len(m.Window().Intervals); n != 6 {
+		t.Fatalf("should advance restored intervals\nhave %d\nwant %d", n, 6)
+	}
+}
+
+func TestMetricsRestoreMergeError(t *testing.T) {
+	b := newTestSnapshot(t)
+	m, _ := newTestMetrics()
+	m.Buckets([]string{"test"}, metrics.NewLinearBuckets(1000, 1000, 1))
+	m.Put([]string{"test"}, 1)
+	err := m.Restore(b)
+	if !errors.Is(err, metrics.ErrBucketMismatch) {
+		t.Fatalf("Restore\nhave %v\nwant %v", err, metrics.ErrBucketMismatch)
+	}
+	if have := m.Window().Intervals[0].Counter([]string{"test"}).Value; have != 1 {
+		t.Fatalf("should restore the remaining metrics\nhave %f\nwant %f", have, 1.0)
+	}
+}
+
+func TestMetricsRestoreStale(t *testing.T) {
+	b := newTestSnapshot(t)
+	clock := metrics.NewManualClock(testTime.Add(testWindow + testInterval))
+	m := metrics.New(testWindow, testInterval,
+		metrics.WithClock(clock),
+		metrics.WithRollup(10*testWindow, testWindow),
+	)
+	err := m.Restore(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w := m.Window()
+	if len(w.Intervals) != 10 {
+		t.Fatalf("should cap to window\nhave %d\nwant %d", len(w.Intervals), 10)
+	}
+	if have := w.Counter([]string{"test"}).Value; have != 3 {
+		t.Fatalf("should discard stale intervals\nhave %f\nwant %f", have, 3.0)
+	}
+	w = m.WindowAt(testWindow)
+	if have := w.Counter([]string{"test"}).Value; have != 3 {
+		t.Fatalf("should roll up stale intervals\nhave %f\nwant %f", have, 3.0)
+	}
+}
+
+func TestMetricsSnapshotFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "metrics.json")
+	clock := metrics.NewManualClock(testTime)
+	m := metrics.New(testWindow, testInterval, metrics.WithClock(clock), metrics.WithSnapshot(name, testInterval))
+	m.Add([]string{"test"}, 1)
+	clock.Advance(testInterval)
+	m.Tick()
+	m.Add([]string{"test"}, 2)
+	err := m.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m = metrics.New(testWindow, testInterval, metrics.WithClock(clock), metrics.WithSnapshot(name, testInterval))
+	w := m.Window()
+	if len(w.Intervals) != 2 {
+		t.Fatalf("should restore the snapshot\nhave %d\nwant %d", len(w.Intervals), 2)
+	}
+	if have := w.Counter([]string{"test"}).Value; have != 3 {
+		t.Fatalf("Value\nhave %f\nwant %f", have, 3.0)
+	}
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(name), "*.tmp"))
+	if len(matches) != 0 {
+		t.Fatalf("should remove temporary files\nhave %v", matches)
+	}
+}
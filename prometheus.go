@@ -0,0 +1,309 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Content types of the supported exposition formats.
+const (
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// ErrMetricNameCollision is returned when the family or sample
+// names of different metrics collide, such as those of the key
+// paths /http/requests and /http_requests.
+var ErrMetricNameCollision = errors.New("metrics: metric name collision")
+
+// WritePrometheus writes the last completed interval, or the
+// current interval if no interval has completed, to w in the
+// Prometheus text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	return writeExposition(w, m.last(), m.metadataFor, false)
+}
+
+// WriteOpenMetrics writes the last completed interval, or the
+// current interval if no interval has completed, to w in the
+// OpenMetrics text exposition format.
+func (m *Metrics) WriteOpenMetrics(w io.Writer) error {
+	return writeExposition(w, m.last(), m.metadataFor, true)
+}
+
+// PrometheusHandler returns an http.Handler that serves the last
+// completed interval in the OpenMetrics text exposition format if
+// accepted by the client, or the Prometheus text exposition format
+// otherwise. Errors writing the response are reported to the error
+// handler.
+func PrometheusHandler(m *Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		if strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+			w.Header().Set("Content-Type", openMetricsContentType)
+			err = m.WriteOpenMetrics(w)
+		} else {
+			w.Header().Set("Content-Type", prometheusContentType)
+			err = m.WritePrometheus(w)
+		}
+		if err != nil {
+			m.errorHandler(err)
+		}
+	})
+}
+
+// writeExposition writes i to w in the Prometheus text exposition
+// format 0.0.4, or the OpenMetrics 1.0 format if om is true.
+// Counters are suffixed with _total, histograms are written as
+// cumulative buckets and summaries, sketches and HDR histograms as
+// the 0.5, 0.9 and 0.99 quantiles, all with _sum and _count series.
+// The metadata returned by meta for each key path is written as HELP
+// and, for OpenMetrics only, UNIT lines. Labels named le of histograms
+// and quantile of summaries, which would collide with the bucket and
+// quantile labels, are renamed exported_le and exported_quantile.
+//
+// Metrics whose family or sample names collide with those of a
+// preceding metric, such as a gauge at /a_count and a histogram at /a,
+// are skipped, and a *KeyError wrapping ErrMetricNameCollision is
+// returned once the rest are written.
+func writeExposition(w io.Writer, i *Interval, meta func(string) Metadata, om bool) error {
+	bw := bufio.NewWriter(w)
+	var prev series
+	all, skipped := i.series()
+	written := make(map[string]bool)
+	skip := false
+	for n, s := range all {
+		md := meta(s.path)
+		name := MetricName(s.path)
+		unit := ""
+		if om && md.Unit != "" {
+			unit = MetricName(md.Unit)
+			if !strings.HasSuffix(name, "_"+unit) {
+				name += "_" + unit
+			}
+		}
+		if n == 0 || s.path != prev.path || s.kind != prev.kind {
+			prev = s
+			// OpenMetrics counter families omit the _total suffix
+			// and share the name of other kinds at the key path.
+			family := name
+			if s.kind == kindCounter && !om {
+				family += "_total"
+			}
+			names := exposedNames(family, name, s.kind)
+			skip = false
+			for _, name := range names {
+				skip = skip || written[name]
+			}
+			if skip {
+				if skipped == nil {
+					skipped = &KeyError{Key: s.key, Err: ErrMetricNameCollision}
+				}
+				continue
+			}
+			for _, name := range names {
+				written[name] = true
+			}
+			switch s.kind {
+			case kindCounter:
+				bw.WriteString("# TYPE " + family + " counter\n")
+			case kindGauge:
+				bw.WriteString("# TYPE " + family + " gauge\n")
+			case kindHistogram:
+				bw.WriteString("# TYPE " + family + " histogram\n")
+			case kindSummary, kindSketch, kindHDR:
+				bw.WriteString("# TYPE " + family + " summary\n")
+			}
+			if unit != "" {
+				bw.WriteString("# UNIT " + family + " " + unit + "\n")
+			}
+			if md.Help != "" {
+				bw.WriteString("# HELP " + family + " " + escapeHelp(md.Help, om) + "\n")
+			}
+		} else if skip {
+			continue
+		}
+		switch s.kind {
+		case kindHistogram:
+			s.labels = exportedLabels(s.labels, "le")
+		case kindSummary, kindSketch, kindHDR:
+			s.labels = exportedLabels(s.labels, "quantile")
+		}
+		labels := formatLabels(s.labels)
+		switch v := s.value.(type) {
+		case *Counter:
+			bw.WriteString(name + "_total" + labels + " " + formatFloat(v.Value) + "\n")
+		case *Gauge:
+			bw.WriteString(name + labels + " " + formatFloat(v.Value) + "\n")
+		case *Histogram:
+			n := uint64(0)
+			for _, b := range v.Buckets {
+				n += b.Count
+				le := formatFloat(b.Value)
+				if om {
+					le = canonicalFloat(b.Value)
+				}
+				bw.WriteString(name + "_bucket" + formatLabels(s.labels, Label{"le", le}) + " " + strconv.FormatUint(n, 10) + "\n")
+			}
+			bw.WriteString(name + "_bucket" + formatLabels(s.labels, Label{"le", "+Inf"}) + " " + strconv.FormatUint(v.Count, 10) + "\n")
+			bw.WriteString(name + "_sum" + labels + " " + formatFloat(v.Sum) + "\n")
+			bw.WriteString(name + "_count" + labels + " " + strconv.FormatUint(v.Count, 10) + "\n")
+		case *Summary:
+			if v.Count > 0 {
+				for _, q := range summaryQuantiles {
+					quantile := Label{"quantile", formatFloat(q)}
+					bw.WriteString(name + formatLabels(s.labels, quantile) + " " + formatFloat(v.Percentile(q)) + "\n")
+				}
+			}
+			bw.WriteString(name + "_sum" + labels + " " + formatFloat(v.Sum) + "\n")
+			bw.WriteString(name + "_count" + labels + " " + strconv.FormatUint(v.Count, 10) + "\n")
+		case *Sketch:
+			if v.Count > 0 {
+				for _, q := range summaryQuantiles {
+					quantile := Label{"quantile", formatFloat(q)}
+					bw.WriteString(name + formatLabels(s.labels, quantile) + " " + formatFloat(v.Percentile(q)) + "\n")
+				}
+			}
+			bw.WriteString(name + "_sum" + labels + " " + formatFloat(v.Sum) + "\n")
+			bw.WriteString(name + "_count" + labels + " " + strconv.FormatUint(v.Count, 10) + "\n")
+		case *HDRHistogram:
+			if v.Count > 0 {
+				for _, q := range summaryQuantiles {
+					quantile := Label{"quantile", formatFloat(q)}
+					bw.WriteString(name + formatLabels(s.labels, quantile) + " " + strconv.FormatInt(v.Percentile(q), 10) + "\n")
+				}
+			}
+			bw.WriteString(name + "_sum" + labels + " " + formatFloat(v.Sum) + "\n")
+			bw.WriteString(name + "_count" + labels + " " + strconv.FormatUint(v.Count, 10) + "\n")
+		}
+	}
+	if om {
+		bw.WriteString("# EOF\n")
+	}
+	err := bw.Flush()
+	if err != nil {
+		return err
+	}
+	return skipped
+}
+
+// exposedNames returns the family name and the names of the samples
+// written for a metric of the kind with the metric name.
+func exposedNames(family, name, kind string) []string {
+	switch kind {
+	case kindCounter:
+		return []string{family, name + "_total"}
+	case kindHistogram:
+		return []string{family, name + "_bucket", name + "_sum", name + "_count"}
+	case kindSummary, kindSketch, kindHDR:
+		return []string{family, name, name + "_sum", name + "_count"}
+	}
+	return []string{family, name}
+}
+
+// summaryQuantiles are the quantiles exposed for summaries.
+var summaryQuantiles = []float64{0.5, 0.9, 0.99}
+
+// formatLabels formats the labels followed by the
+// extra labels, or returns the empty string if none.
+func formatLabels(labels []Label, extra ...Label) string {
+	if len(labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for _, l := range append(labels[:len(labels):len(labels)], extra...) {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name + `="` + escapeLabelValue(l.Value) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// exportedLabels returns the labels with the label named reserved
+// renamed with an exported_ prefix, as Prometheus does for scraped
+// labels that collide with its own.
+func exportedLabels(labels []Label, reserved string) []Label {
+	for n, l := range labels {
+		if l.Name == reserved {
+			renamed := append([]Label(nil), labels...)
+			renamed[n].Name = "exported_" + reserved
+			return renamed
+		}
+	}
+	return labels
+}
+
+// escapeHelp escapes the help text for a HELP line. OpenMetrics
+// additionally requires double quotes to be escaped.
+func escapeHelp(s string, om bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if om {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
+
+// MetricName returns the metric name for the key path. The leading
+// slash is removed, the remaining slashes and any other characters
+// outside of [a-zA-Z0-9_] are replaced by underscores, and an
+// underscore is prefixed if the name would begin with a digit.
+// For example, the key path /http/requests-total becomes
+// http_requests_total.
+func MetricName(path string) string {
+	path = strings.TrimPrefix(path, "/")
+	b := make([]byte, 0, len(path)+1)
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b = append(b, '_')
+			}
+		default:
+			c = '_'
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
+
+// splitKind splits the stored key into the key path and metric kind.
+func splitKind(k string) (string, string) {
+	n := strings.LastIndex(k, ":")
+	if n < 0 {
+		return k, ""
+	}
+	return k[:n], k[n:]
+}
+
+// canonicalFloat formats f as a canonical OpenMetrics number
+// where integral values require a trailing fractional part.
+func canonicalFloat(f float64) string {
+	s := formatFloat(f)
+	if strings.ContainsAny(s, ".eEIN") {
+		return s
+	}
+	return s + ".0"
+}
+
+// formatFloat formats f as expected by the exposition formats.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
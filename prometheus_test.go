@@ -0,0 +1,242 @@
+package metrics_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pnelson/metrics"
+)
+
+func TestMetricsWritePrometheus(t *testing.T) {
+	m, _ := newTestMetrics()
+	m.Buckets([]string{"http", "latency"}, metrics.NewLinearBuckets(10, 10, 2))
+	m.Add([]string{"http", "requests"}, 3)
+	m.Set([]string{"memstats", "alloc"}, 1024)
+	m.Put([]string{"http", "latency"}, 5)
+	m.Put([]string{"http", "latency"}, 15)
+	m.Put([]string{"http", "latency"}, 25)
+	var b bytes.Buffer
+	err := m.WritePrometheus(&b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `# TYPE http_latency histogram
+http_latency_bucket{le="10"} 1
+http_latency_bucket{le="20"} 2
+http_latency_bucket{le="+Inf"} 3
+http_latency_sum 45
+http_latency_count 3
+# TYPE http_requests_total counter
+http_requests_total 3
+# TYPE memstats_alloc gauge
+memstats_alloc 1024
+`
+	if have := b.String(); have != want {
+		t.Fatalf("prometheus\nhave %s\nwant %s", have, want)
+	}
+}
+
+func TestMetricsWritePrometheusLabels(t *testing.T) {
+	m, _ := newTestMetrics()
+	m.Buckets([]string{"http", "latency"}, metrics.NewLinearBuckets(10, 10, 1))
+	m.Add([]string{"http", "requests"}, 3, metrics.Label{Name: "method", Value: "GET"})
+	m.Add([]string{"http", "requests"}, 1, metrics.Label{Name: "method", Value: "POST"})
+	m.Add([]string{"http", "requests2"}, 1)
+	m.Put([]string{"http", "latency"}, 5, metrics.Label{Name: "path", Value: "/\"a\""})
+	var b bytes.Buffer
+	err := m.WritePrometheus(&b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `# TYPE http_latency histogram
+http_latency_bucket{path="/\"a\"",le="10"} 1
+http_latency_bucket{path="/\"a\"",le="+Inf"} 1
+http_latency_sum{path="/\"a\""} 5
+http_latency_count{path="/\"a\""} 1
+# TYPE http_requests_total counter
+http_requests_total{method="GET"} 3
+http_requests_total{method="POST"} 1
+# TYPE http_requests2_total counter
+http_requests2_total 1
+`
+	if have := b.String(); have != want {
+		t.Fatalf("prometheus\nhave %s\nwant %s", have, want)
+	}
+}
+
+func TestMetricsWritePrometheusReservedLabels(t *testing.T) {
+	m, _ := newTestMetrics()
+	m.Buckets([]string{"latency"}, metrics.NewLinearBuckets(10, 10, 1))
+	m.Put([]string{"latency"}, 5, metrics.Label{Name: "le", Value: "a"})
+	m.Observe([]string{"size"}, 5, metrics.Label{Name: "quantile", Value: "b"})
+	var b bytes.Buffer
+	err := m.WritePrometheus(&b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `# TYPE latency histogram
+latency_bucket{exported_le="a",le="10"} 1
+latency_bucket{exported_le="a",le="+Inf"} 1
+latency_sum{exported_le="a"} 5
+latency_count{exported_le="a"} 1
+# TYPE size summary
+size{exported_quantile="b",quantile="0.5"} 5
+size{exported_quantile="b",quantile="0.9"} 5
+size{exported_quantile="b",quantile="0.99"} 5
+size_sum{exported_quantile="b"} 5
+size_count{exported_quantile="b"} 1
+`
+	if have := b.String(); have != want {
+		t.Fatalf("prometheus\nhave %s\nwant %s", have, want)
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	m, _ := newTestMetrics()
+	m.Add([]string{"test"}, 1)
+	w := httptest.NewRecorder()
+	metrics.PrometheusHandler(m).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Fatalf("Content-Type\nhave %s", ct)
+	}
+	want := "# TYPE test_total counter\ntest_total 1\n"
+	if have := w.Body.String(); have != want {
+		t.Fatalf("body\nhave %s\nwant %s", have, want)
+	}
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	w = httptest.NewRecorder()
+	metrics.PrometheusHandler(m).ServeHTTP(w, r)
+	if ct := w.Header().Get("Content-Type"); ct != "application/openmetrics-text; version=1.0.0; charset=utf-8" {
+		t.Fatalf("Content-Type\nhave %s", ct)
+	}
+	want = "# TYPE test counter\ntest_total 1\n# EOF\n"
+	if have := w.Body.String(); have != want {
+		t.Fatalf("body\nhave %s\nwant %s", have, want)
+	}
+}
+
+func TestMetricsWritePrometheusCollision(t *testing.T) {
+	var errs []error
+	clock := metrics.NewManualClock(testTime)
+	m := metrics.New(testWindow, testInterval,
+		metrics.WithClock(clock),
+		metrics.WithErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+	m.Add([]string{"a", "b"}, 1)
+	m.Add([]string{"a_b"}, 2)
+	w := httptest.NewRecorder()
+	metrics.PrometheusHandler(m).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	want := "# TYPE a_b_total counter\na_b_total 1\n"
+	if have := w.Body.String(); have != want {
+		t.Fatalf("body\nhave %s\nwant %s", have, want)
+	}
+	var keyErr *metrics.KeyError
+	if len(errs) != 1 || !errors.Is(errs[0], metrics.ErrMetricNameCollision) || !errors.As(errs[0], &keyErr) || keyErr.Key != "/a_b:counter" {
+		t.Fatalf("should report the colliding key\nhave %v", errs)
+	}
+}
+
+func TestMetricsWritePrometheusSampleCollision(t *testing.T) {
+	m, _ := newTestMetrics()
+	m.Buckets([]string{"y"}, metrics.NewLinearBuckets(10, 10, 1))
+	m.Put([]string{"y"}, 5)
+	m.Set([]string{"y_count"}, 2)
+	var b bytes.Buffer
+	err := m.WritePrometheus(&b)
+	var keyErr *metrics.KeyError
+	if !errors.Is(err, metrics.ErrMetricNameCollision) || !errors.As(err, &keyErr) || keyErr.Key != "/y_count:gauge" {
+		t.Fatalf("WritePrometheus\nhave %v\nwant the colliding key", err)
+	}
+	want := `# TYPE y histogram
+y_bucket{le="10"} 1
+y_bucket{le="+Inf"} 1
+y_sum 5
+y_count 1
+`
+	if have := b.String(); have != want {
+		t.Fatalf("prometheus\nhave %s\nwant %s", have, want)
+	}
+}
+
+func TestMetricName(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/test", "test"},
+		{"/http/requests", "http_requests"},
+		{"/http/requests-total", "http_requests_total"},
+		{"/2xx/count", "_2xx_count"},
+		{"/a.b/c d", "a_b_c_d"},
+	}
+	for _, tt := range tests {
+		have := metrics.MetricName(tt.path)
+		if have != tt.want {
+			t.Fatalf("MetricName(%q)\nhave %s\nwant %s", tt.path, have, tt.want)
+		}
+	}
+}
+
+func TestMetricsWriteOpenMetrics(t *testing.T) {
+	m, _ := newTestMetrics()
+	m.Metadata([]string{"http"}, metrics.Metadata{Help: "HTTP server \"requests\"."})
+	m.Metadata([]string{"http", "latency"}, metrics.Metadata{Help: "Request latency.", Unit: "milliseconds"})
+	m.Metadata([]string{"memstats", "alloc"}, metrics.Metadata{Unit: "bytes"})
+	m.Buckets([]string{"http", "latency"}, metrics.NewLinearBuckets(10, 10, 2))
+	m.Add([]string{"http", "requests"}, 3)
+	m.Set([]string{"memstats", "alloc"}, 1024)
+	m.Put([]string{"http", "latency"}, 5)
+	m.Put([]string{"http", "latency"}, 25)
+	var b bytes.Buffer
+	err := m.WriteOpenMetrics(&b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `# TYPE http_latency_milliseconds histogram
+# UNIT http_latency_milliseconds milliseconds
+# HELP http_latency_milliseconds Request latency.
+http_latency_milliseconds_bucket{le="10.0"} 1
+http_latency_milliseconds_bucket{le="20.0"} 1
+http_latency_milliseconds_bucket{le="+Inf"} 2
+http_latency_milliseconds_sum 30
+http_latency_milliseconds_count 2
+# TYPE http_requests counter
+# HELP http_requests HTTP server \"requests\".
+http_requests_total 3
+# TYPE memstats_alloc_bytes gauge
+# UNIT memstats_alloc_bytes bytes
+memstats_alloc_bytes 1024
+# EOF
+`
+	if have := b.String(); have != want {
+		t.Fatalf("openmetrics\nhave %s\nwant %s", have, want)
+	}
+}
+
+func TestMetricsWriteOpenMetricsCollision(t *testing.T) {
+	m, _ := newTestMetrics()
+	m.Add([]string{"x"}, 1)
+	m.Set([]string{"x"}, 2)
+	var b bytes.Buffer
+	err := m.WriteOpenMetrics(&b)
+	var keyErr *metrics.KeyError
+	if !errors.Is(err, metrics.ErrMetricNameCollision) || !errors.As(err, &keyErr) || keyErr.Key != "/x:gauge" {
+		t.Fatalf("WriteOpenMetrics\nhave %v\nwant the colliding key", err)
+	}
+	want := "# TYPE x counter\nx_total 1\n# EOF\n"
+	if have := b.String(); have != want {
+		t.Fatalf("openmetrics\nhave %s\nwant %s", have, want)
+	}
+	b.Reset()
+	err = m.WritePrometheus(&b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want = "# TYPE x_total counter\nx_total 1\n# TYPE x gauge\nx 2\n"
+	if have := b.String(); have != want {
+		t.Fatalf("prometheus\nhave %s\nwant %s", have, want)
+	}
+}
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errRollupOrder is reported when an interval is evicted into a rollup
+// after a later rollup interval, such that it cannot be merged.
+var errRollupOrder = errors.New("metrics: interval before the last rollup interval")
+
+// rollup represents a ring of intervals at a coarser resolution
+// merged from the intervals evicted from the next finer ring.
+type rollup struct {
+	window    time.Duration
+	interval  time.Duration
+	intervals []*Interval
+}
+
+// WithRollup adds a coarser resolution of intervals over window.
+// Intervals that fall out of the next finer resolution are merged
+// into the interval at this resolution that contains them. Counters
+// are summed, gauges retain the minimum, maximum and last values,
+// and histograms are merged bucket-wise. Rollups must be given in
+// order of increasing interval, each a multiple of the previous,
+// over a window of at least one interval.
+//
+// For example, to store 10 second intervals for the last hour,
+// 1 minute intervals for the last day and 1 hour intervals for
+// the last month:
+//
+//	m := metrics.New(time.Hour, 10*time.Second,
+//		metrics.WithRollup(24*time.Hour, time.Minute),
+//		metrics.WithRollup(30*24*time.Hour, time.Hour),
+//	)
+func WithRollup(window, interval time.Duration) Option {
+	return func(m *Metrics) {
+		prev := m.interval
+		if len(m.rollups) > 0 {
+			prev = m.rollups[len(m.rollups)-1].interval
+		}
+		if interval <= prev || interval%prev != 0 {
+			panic("metrics: rollup interval must be a multiple of the previous interval")
+		}
+		if window < interval {
+			panic("metrics: rollup window must be at least the rollup interval")
+		}
+		m.rollups = append(m.rollups, &rollup{
+			window:    window,
+			interval:  interval,
+			intervals: make([]*Interval, 0, window/interval),
+		})
+	}
+}
+
+// rollup merges the interval i of duration d evicted from the
+// next finer ring into the rollup at index n, if any, evicting
+// the oldest rollup interval to the next rollup as required.
+// Intervals before the last rollup interval are discarded. The errors
+// encountered are returned to be reported once the caller releases
+// m.mu, such that the error handler may use the metrics. The caller
+// must hold m.mu.
+func (m *Metrics) rollup(n int, i *Interval, d time.Duration) []error {
+	if n >= len(m.rollups) {
+		return nil
+	}
+	r := m.rollups[n]
+	// Interval times mark the end of the interval.
+	t := i.time.Add(-d).Truncate(r.interval).Add(r.interval)
+	var dst *Interval
+	if len(r.intervals) > 0 {
+		dst = r.intervals[len(r.intervals)-1]
+		if t.Before(dst.time) {
+			return []error{fmt.Errorf("%w at %v", errRollupOrder, i.time)}
+		}
+	}
+	var errs []error
+	if dst == nil || t.After(dst.time) {
+		dst = newInterval(t)
+		if len(r.intervals) == cap(r.intervals) {
+			evicted := r.intervals[0]
+			copy(r.intervals, r.intervals[1:])
+			r.intervals[len(r.intervals)-1] = dst
+			errs = m.rollup(n+1, evicted, r.interval)
+		} else {
+			r.intervals = append(r.intervals, dst)
+		}
+	}
+	err := dst.merge(i.snapshot())
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// WindowAt returns a copy of the windowed intervals at the coarsest
+// resolution with an interval no longer than resolution. The finest
+// resolution is returned if resolution is shorter than all intervals.
+func (m *Metrics) WindowAt(resolution time.Duration) Window {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for n := len(m.rollups) - 1; n >= 0; n-- {
+		r := m.rollups[n]
+		if r.interval <= resolution {
+			return newWindow(r.window, r.intervals)
+		}
+	}
+	return newWindow(m.window, m.intervals)
+}
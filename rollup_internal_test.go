@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsRollupOrder(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	m := New(time.Minute, time.Second,
+		WithClock(NewManualClock(now)),
+		WithRollup(time.Hour, time.Minute),
+	)
+	m.mu.Lock()
+	errs := m.rollup(0, newInterval(now.Add(2*time.Minute)), time.Second)
+	errs = append(errs, m.rollup(0, newInterval(now), time.Second)...)
+	m.mu.Unlock()
+	if len(errs) != 1 || !errors.Is(errs[0], errRollupOrder) {
+		t.Fatalf("should return the interval out of order\nhave %v", errs)
+	}
+}
+
+func TestMetricsRollupErrorHandler(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	var m *Metrics
+	var errs []error
+	m = New(time.Minute, time.Second,
+		WithClock(NewManualClock(now)),
+		WithErrorHandler(func(err error) {
+			m.Window()
+			errs = append(errs, err)
+		}),
+		WithRollup(time.Hour, time.Minute),
+	)
+	m.mu.Lock()
+	m.rollup(0, newInterval(now.Add(2*time.Minute)), time.Second)
+	m.mu.Unlock()
+	stale := strconv.FormatInt(now.Add(-2*time.Minute).UnixMilli(), 10)
+	snapshot := `{"resolutions":[{"interval":1000,"window":{"duration":60,"intervals":[{"time":` + stale + `,"metrics":{}}]}}]}`
+	done := make(chan error, 1)
+	go func() { done <- m.Restore(strings.NewReader(snapshot)) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("should report errors without holding the lock")
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], errRollupOrder) {
+		t.Fatalf("should report the interval out of order\nhave %v", errs)
+	}
+}
@@ -0,0 +1,70 @@
+package metrics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pnelson/metrics"
+)
+
+func TestMetricsRollup(t *testing.T) {
+	clock := metrics.NewManualClock(testTime)
+	m := metrics.New(4*testInterval, testInterval,
+		metrics.WithClock(clock),
+		metrics.WithRollup(10*testInterval, 2*testInterval),
+		metrics.WithRollup(40*testInterval, 10*testInterval),
+	)
+	m.Buckets([]string{"h"}, metrics.NewLinearBuckets(10, 10, 2))
+	for n := 0; n < 20; n++ {
+		if n > 0 {
+			clock.Advance(testInterval)
+			m.Tick()
+		}
+		m.Add([]string{"c"}, 1)
+		m.Set([]string{"g"}, float64(n+1))
+		m.Put([]string{"h"}, 10)
+	}
+	tests := []struct {
+		resolution time.Duration
+		intervals  int
+		counter    float64
+		gauge      metrics.Gauge
+	}{
+		{0, 4, 1, metrics.Gauge{Min: 20, Max: 20, Value: 20, Count: 1}},
+		{testInterval, 4, 1, metrics.Gauge{Min: 20, Max: 20, Value: 20, Count: 1}},
+		{2 * testInterval, 5, 2, metrics.Gauge{Min: 15, Max: 16, Value: 16, Count: 2}},
+		{5 * testInterval, 5, 2, metrics.Gauge{Min: 15, Max: 16, Value: 16, Count: 2}},
+		{time.Hour, 1, 6, metrics.Gauge{Min: 1, Max: 6, Value: 6, Count: 6}},
+	}
+	for _, tt := range tests {
+		w := m.WindowAt(tt.resolution)
+		if len(w.Intervals) != tt.intervals {
+			t.Fatalf("WindowAt(%v)\nhave %d intervals\nwant %d", tt.resolution, len(w.Intervals), tt.intervals)
+		}
+		i := &w.Intervals[len(w.Intervals)-1]
+		if c := i.Counter([]string{"c"}); c.Value != tt.counter {
+			t.Fatalf("WindowAt(%v) Counter\nhave %f\nwant %f", tt.resolution, c.Value, tt.counter)
+		}
+		if g := i.Gauge([]string{"g"}); g != tt.gauge {
+			t.Fatalf("WindowAt(%v) Gauge\nhave %+v\nwant %+v", tt.resolution, g, tt.gauge)
+		}
+		if h := i.Histogram([]string{"h"}); h.Count != uint64(tt.counter) || h.Buckets[0].Count != uint64(tt.counter) {
+			t.Fatalf("WindowAt(%v) Histogram\nhave %+v", tt.resolution, h)
+		}
+	}
+	w := m.WindowAt(2 * testInterval)
+	for n := 1; n < len(w.Intervals); n++ {
+		if d := w.Intervals[n].Time().Sub(w.Intervals[n-1].Time()); d != 2*testInterval {
+			t.Fatalf("should align rollup intervals\nhave %v\nwant %v", d, 2*testInterval)
+		}
+	}
+}
+
+func TestWithRollupWindow(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("should panic for a window shorter than the interval")
+		}
+	}()
+	metrics.New(testWindow, testInterval, metrics.WithRollup(time.Second, time.Minute))
+}
@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+const kindSketch = ":sketch"
+
+// DefaultAccuracy is the default relative accuracy of sketches.
+const DefaultAccuracy = 0.01
+
+// ErrAccuracyMismatch is returned when merging sketches
+// that do not share the same relative accuracy.
+var ErrAccuracyMismatch = errors.New("metrics: mismatched sketch accuracy")
+
+// Sketch represents a distribution of samples counted in
+// logarithmically sized bins, such that every percentile is
+// estimated within the relative accuracy of the true value.
+// Bins are created as samples are added, so any range of values
+// is recorded without configuring buckets. Sketches of the same
+// accuracy merge exactly.
+//
+// Positive samples are counted in the bin at index i that holds
+// values in (γ^(i-1), γ^i], where γ = (1+accuracy)/(1-accuracy),
+// negative samples are counted by their absolute value and zero
+// is counted separately. Samples that are not finite are ignored.
+type Sketch struct {
+	Min      float64        `json:"min"`
+	Max      float64        `json:"max"`
+	Sum      float64        `json:"sum"`
+	Count    uint64         `json:"count"`
+	Accuracy float64        `json:"accuracy"`
+	Zero     uint64         `json:"zero"`
+	Positive map[int]uint64 `json:"positive"`
+	Negative map[int]uint64 `json:"negative"`
+}
+
+// NewSketch returns a new sketch with the relative accuracy.
+// DefaultAccuracy is used if accuracy is not between 0 and 1.
+func NewSketch(accuracy float64) *Sketch {
+	if !(accuracy > 0 && accuracy < 1) {
+		accuracy = DefaultAccuracy
+	}
+	return &Sketch{Accuracy: accuracy}
+}
+
+// Put adds value as a sample.
+func (m *Sketch) Put(value float64) {
+	if !finite(value) {
+		return
+	}
+	if value < m.Min || m.Count == 0 {
+		m.Min = value
+	}
+	if value > m.Max || m.Count == 0 {
+		m.Max = value
+	}
+	m.Sum += value
+	m.Count++
+	switch {
+	case value > 0:
+		if m.Positive == nil {
+			m.Positive = make(map[int]uint64)
+		}
+		m.Positive[m.index(value)]++
+	case value < 0:
+		if m.Negative == nil {
+			m.Negative = make(map[int]uint64)
+		}
+		m.Negative[m.index(-value)]++
+	default:
+		m.Zero++
+	}
+}
+
+// Merge merges the samples of o into the sketch. The accuracy of o
+// is adopted if the sketch has no accuracy or samples.
+// ErrAccuracyMismatch is returned if the accuracy differs.
+func (m *Sketch) Merge(o Sketch) error {
+	if m.Count == 0 && (m.Accuracy == 0 || o.Count > 0) {
+		m.Accuracy = o.Accuracy
+	}
+	if o.Count == 0 {
+		return nil
+	}
+	if m.Accuracy != o.Accuracy {
+		return ErrAccuracyMismatch
+	}
+	if o.Min < m.Min || m.Count == 0 {
+		m.Min = o.Min
+	}
+	if o.Max > m.Max || m.Count == 0 {
+		m.Max = o.Max
+	}
+	m.Sum += o.Sum
+	m.Count += o.Count
+	m.Zero += o.Zero
+	m.Positive = mergeBins(m.Positive, o.Positive)
+	m.Negative = mergeBins(m.Negative, o.Negative)
+	return nil
+}
+
+// mergeBins adds the counts of the bins of src to dst.
+func mergeBins(dst, src map[int]uint64) map[int]uint64 {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[int]uint64, len(src))
+	}
+	for i, n := range src {
+		dst[i] += n
+	}
+	return dst
+}
+
+// Percentile returns the estimated value below which a given
+// percentage of the samples fall, within the relative accuracy.
+func (m Sketch) Percentile(p float64) float64 {
+	if m.Count == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return m.Min
+	}
+	if p >= 1 {
+		return m.Max
+	}
+	rank := uint64(p * float64(m.Count-1))
+	return math.Max(m.Min, math.Min(m.Max, m.valueAt(rank)))
+}
+
+// valueAt returns the value of the bin counting
+// the sample at rank from the lowest sample.
+func (m *Sketch) valueAt(rank uint64) float64 {
+	n := uint64(0)
+	negative := sortedBins(m.Negative)
+	for k := len(negative) - 1; k >= 0; k-- {
+		n += m.Negative[negative[k]]
+		if n > rank {
+			return -m.value(negative[k])
+		}
+	}
+	n += m.Zero
+	if n > rank {
+		return 0
+	}
+	for _, i := range sortedBins(m.Positive) {
+		n += m.Positive[i]
+		if n > rank {
+			return m.value(i)
+		}
+	}
+	return m.Max
+}
+
+// gamma returns the ratio of the bounds of a bin.
+func (m *Sketch) gamma() float64 {
+	accuracy := m.Accuracy
+	if !(accuracy > 0 && accuracy < 1) {
+		accuracy = DefaultAccuracy
+	}
+	return (1 + accuracy) / (1 - accuracy)
+}
+
+// index returns the index of the bin for the positive value.
+func (m *Sketch) index(value float64) int {
+	return int(math.Ceil(math.Log(value) / math.Log(m.gamma())))
+}
+
+// value returns the value of the bin at index i, the value
+// within the relative accuracy of every value in the bin.
+func (m *Sketch) value(i int) float64 {
+	gamma := m.gamma()
+	return 2 * math.Pow(gamma, float64(i)) / (gamma + 1)
+}
+
+// sortedBins returns the indexes of the bins in ascending order.
+func sortedBins(bins map[int]uint64) []int {
+	indexes := make([]int, 0, len(bins))
+	for i := range bins {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// copySketch returns a deep copy of the sketch.
+func copySketch(s *Sketch) *Sketch {
+	c := new(Sketch)
+	*c = *s
+	c.Positive = mergeBins(nil, s.Positive)
+	c.Negative = mergeBins(nil, s.Negative)
+	return c
+}
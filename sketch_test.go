@@ -0,0 +1,122 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/pnelson/metrics"
+)
+
+func TestSketchPercentile(t *testing.T) {
+	m := metrics.NewSketch(metrics.DefaultAccuracy)
+	r := rand.New(rand.NewSource(1))
+	values := make([]float64, 10000)
+	for n := range values {
+		// Log-uniform magnitudes from 1e-6 to 1e6 with either sign.
+		values[n] = math.Pow(10, r.Float64()*12-6)
+		if n%4 == 0 {
+			values[n] = -values[n]
+		}
+		if n%100 == 0 {
+			values[n] = 0
+		}
+		m.Put(values[n])
+	}
+	m.Put(math.NaN())
+	m.Put(math.Inf(1))
+	sort.Float64s(values)
+	if m.Count != uint64(len(values)) || m.Min != values[0] || m.Max != values[len(values)-1] {
+		t.Fatalf("Sketch\nhave count %d, min %f and max %f", m.Count, m.Min, m.Max)
+	}
+	for _, p := range []float64{0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999} {
+		want := values[int(p*float64(len(values)-1))]
+		have := m.Percentile(p)
+		if math.Abs(have-want) > metrics.DefaultAccuracy*math.Abs(want) {
+			t.Fatalf("Percentile(%v)\nhave %g\nwant %g within %v", p, have, want, metrics.DefaultAccuracy)
+		}
+	}
+	m = metrics.NewSketch(metrics.DefaultAccuracy)
+	m.Put(-2)
+	m.Put(0)
+	m.Put(3)
+	if have := m.Percentile(0.5); have != 0 {
+		t.Fatalf("Percentile of zero\nhave %g\nwant 0", have)
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	a := metrics.NewSketch(metrics.DefaultAccuracy)
+	b := metrics.NewSketch(metrics.DefaultAccuracy)
+	all := metrics.NewSketch(metrics.DefaultAccuracy)
+	for v := -500; v <= 1000; v++ {
+		if v%3 == 0 {
+			a.Put(float64(v) / 7)
+		} else {
+			b.Put(float64(v) / 7)
+		}
+		all.Put(float64(v) / 7)
+	}
+	err := a.Merge(*b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The sum is not exact in floating point.
+	a.Sum, all.Sum = 0, 0
+	if !reflect.DeepEqual(a, all) {
+		t.Fatalf("should merge exactly\nhave %v\nwant %v", a, all)
+	}
+	c := metrics.NewSketch(0.05)
+	c.Put(1)
+	err = a.Merge(*c)
+	if !errors.Is(err, metrics.ErrAccuracyMismatch) {
+		t.Fatalf("Merge\nhave %v\nwant %v", err, metrics.ErrAccuracyMismatch)
+	}
+}
+
+func TestMetricsSample(t *testing.T) {
+	m, clock := newTestMetrics()
+	m.Accuracy([]string{"db"}, 0.001)
+	for n := 0; n < 3; n++ {
+		for v := 1; v <= 100; v++ {
+			m.Sample([]string{"db", "latency"}, float64(n*100+v))
+		}
+		m.Sample([]string{"queue", "depth"}, float64(n))
+		clock.Advance(testInterval)
+		m.Tick()
+	}
+	w := m.Window()
+	if have := w.Intervals[0].Sketch([]string{"queue", "depth"}); have.Count != 1 || have.Zero != 1 || have.Accuracy != metrics.DefaultAccuracy {
+		t.Fatalf("Interval.Sketch\nhave %v\nwant a zero sample with the default accuracy", have)
+	}
+	s, err := w.Sketch([]string{"db", "latency"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Count != 300 || s.Min != 1 || s.Max != 300 || s.Accuracy != 0.001 {
+		t.Fatalf("Window.Sketch\nhave count %d, min %f, max %f and accuracy %f", s.Count, s.Min, s.Max, s.Accuracy)
+	}
+	if have := s.Percentile(0.5); math.Abs(have-150) > 0.001*150 {
+		t.Fatalf("Percentile\nhave %f\nwant %f", have, 150.0)
+	}
+	b, err := json.Marshal(w)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var decoded metrics.Window
+	err = json.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	have, err := decoded.Sketch([]string{"db", "latency"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(have, s) {
+		t.Fatalf("Sketch after JSON\nhave %v\nwant %v", have, s)
+	}
+}
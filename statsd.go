@@ -0,0 +1,231 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// StatsD server limits.
+const (
+	statsdPacketSize = 65536 // bytes
+	statsdQueue      = 1024  // packets
+	statsdSamples    = 10000 // samples per sampled value
+)
+
+// errStatsDLine is returned when parsing an invalid StatsD line.
+var errStatsDLine = errors.New("metrics: invalid statsd line")
+
+// StatsDServer records the metrics received in the StatsD line
+// protocol. Counters (c) are recorded with Add, scaled by the sample
+// rate. Gauges (g) are recorded with Set, or with Mod if the value is
+// signed. Timers (ms), histograms (h) and distributions (d) are
+// recorded with Put as a single sample weighted by the inverse of the
+// sample rate, up to 10000.
+//
+// The dotted names are split into key segments. DogStatsD tags
+// are appended to the key as segments of the tag name and value,
+// if any, sorted by tag name. For example, api.requests:1|c|#env:prod
+// is recorded to the counter at /api/requests/env/prod.
+//
+// Lines that cannot be parsed are counted by the counter at
+// /statsd/errors. Packets received faster than they can be parsed
+// are dropped and counted by the counter at /statsd/dropped.
+type StatsDServer struct {
+	m       *Metrics
+	errors  *CounterHandle
+	dropped *CounterHandle
+
+	mu     sync.Mutex
+	conns  map[net.PacketConn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewStatsDServer returns a new StatsD server recording into m.
+func NewStatsDServer(m *Metrics) *StatsDServer {
+	return &StatsDServer{
+		m:       m,
+		errors:  m.CounterHandle([]string{"statsd", "errors"}),
+		dropped: m.CounterHandle([]string{"statsd", "dropped"}),
+		conns:   make(map[net.PacketConn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the UDP network address addr
+// and serves the packets received until the server is closed.
+func (s *StatsDServer) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serve serves the packets received on conn until the server is
+// closed, returning net.ErrClosed, or returns the error reading from
+// conn. The packets read are parsed before Serve returns. Serve
+// closes conn.
+func (s *StatsDServer) Serve(conn net.PacketConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return net.ErrClosed
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+	defer s.wg.Done()
+	packets := make(chan []byte, statsdQueue)
+	parsed := make(chan struct{})
+	go func() {
+		defer close(parsed)
+		for b := range packets {
+			s.parse(b)
+		}
+	}()
+	buf := make([]byte, statsdPacketSize)
+	var err error
+	for {
+		var n int
+		n, _, err = conn.ReadFrom(buf)
+		if n > 0 {
+			b := make([]byte, n)
+			copy(b, buf[:n])
+			s.enqueue(packets, b)
+		}
+		if err != nil {
+			break
+		}
+	}
+	close(packets)
+	<-parsed
+	s.mu.Lock()
+	delete(s.conns, conn)
+	closed := s.closed
+	s.mu.Unlock()
+	conn.Close()
+	if closed {
+		return net.ErrClosed
+	}
+	return err
+}
+
+// Close closes the connections being served and waits
+// for the packets read to be parsed.
+func (s *StatsDServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	for conn := range s.conns {
+		if closeErr := conn.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// enqueue queues the packet b to be parsed,
+// or drops the packet if the queue is full.
+func (s *StatsDServer) enqueue(packets chan<- []byte, b []byte) {
+	select {
+	case packets <- b:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// parse records the metrics of each line of the packet b.
+func (s *StatsDServer) parse(b []byte) {
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" || strings.HasPrefix(line, "_e{") || strings.HasPrefix(line, "_sc|") {
+			// DogStatsD events and service checks are not metrics.
+			continue
+		}
+		if s.parseLine(line) != nil {
+			s.errors.Add(1)
+		}
+	}
+}
+
+// parseLine records the metric of the line
+// name:value[:value...]|type[|@rate][|#tags].
+func (s *StatsDServer) parseLine(line string) error {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok {
+		return errStatsDLine
+	}
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 {
+		return errStatsDLine
+	}
+	rate := 1.0
+	var tags []string
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "@"):
+			var err error
+			rate, err = strconv.ParseFloat(f[1:], 64)
+			if err != nil || !(rate > 0 && rate <= 1) {
+				return errStatsDLine
+			}
+		case strings.HasPrefix(f, "#"):
+			tags = strings.Split(f[1:], ",")
+		}
+	}
+	key := strings.Split(name, ".")
+	sort.Strings(tags)
+	for _, tag := range tags {
+		k, v, ok := strings.Cut(tag, ":")
+		key = append(key, k)
+		if ok {
+			key = append(key, v)
+		}
+	}
+	if ValidateKey(key) != nil {
+		return errStatsDLine
+	}
+	raw := strings.Split(fields[0], ":")
+	values := make([]float64, len(raw))
+	for n, v := range raw {
+		var err error
+		values[n], err = strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(values[n]) || math.IsInf(values[n], 0) {
+			return errStatsDLine
+		}
+	}
+	switch fields[1] {
+	case "c":
+		for _, v := range values {
+			s.m.Add(key, v/rate)
+		}
+	case "g":
+		for n, v := range values {
+			if raw[n][0] == '+' || raw[n][0] == '-' {
+				s.m.Mod(key, v)
+			} else {
+				s.m.Set(key, v)
+			}
+		}
+	case "ms", "h", "d":
+		samples := uint64(statsdSamples)
+		if 1/rate < statsdSamples {
+			samples = uint64(math.Round(1 / rate))
+		}
+		k := canonicalKey(key, nil, kindHistogram)
+		for _, v := range values {
+			s.m.put(k, v, samples)
+		}
+	default:
+		return errStatsDLine
+	}
+	return nil
+}
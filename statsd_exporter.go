@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// defaultStatsDPacketSize is the default maximum packet size in bytes,
+// fitting the Ethernet MTU after the IP and UDP headers.
+const defaultStatsDPacketSize = 1432
+
+// StatsDExporter exports completed intervals to a StatsD daemon.
+// Counters are sent as counts (c) and gauges as the last value (g).
+// Histograms are sent as a timing (ms) per bucket with samples,
+// valued at the bucket value limited to the histogram minimum and
+// maximum, with a sample rate of the inverse of the bucket count.
+// Samples beyond the last bucket are sent valued at the maximum.
+// Summaries, sketches and HDR histograms are sent as the .count and
+// .sum counts and the .p50 and .p99 gauges.
+//
+// Key paths are sent as dotted names. Label names and values are
+// appended as name segments, or sent as tags to DogStatsD. Lines
+// are batched into packets up to the maximum packet size.
+type StatsDExporter struct {
+	conn       net.Conn
+	dogstatsd  bool
+	packetSize int
+}
+
+// StatsDOption represents a functional option for
+// configuring the StatsD exporter.
+type StatsDOption func(*StatsDExporter)
+
+// WithDogStatsD sends labels as tags and histograms as
+// distributions (d) in the DogStatsD line protocol.
+func WithDogStatsD() StatsDOption {
+	return func(e *StatsDExporter) {
+		e.dogstatsd = true
+	}
+}
+
+// WithStatsDPacketSize sets the maximum packet size in bytes.
+// The default is 1432 bytes.
+func WithStatsDPacketSize(n int) StatsDOption {
+	return func(e *StatsDExporter) {
+		e.packetSize = n
+	}
+}
+
+// NewStatsDExporter returns a new StatsD exporter
+// sending to the UDP network address addr.
+func NewStatsDExporter(addr string, opts ...StatsDOption) (*StatsDExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	e := &StatsDExporter{conn: conn, packetSize: defaultStatsDPacketSize}
+	for _, option := range opts {
+		option(e)
+	}
+	return e, nil
+}
+
+// Export implements the Exporter interface. An error is returned
+// if a packet could not be sent, or a *KeyError if a key of the
+// interval could not be parsed.
+func (e *StatsDExporter) Export(i *Interval) error {
+	var packet []byte
+	var err error
+	send := func() {
+		if len(packet) == 0 {
+			return
+		}
+		if _, writeErr := e.conn.Write(packet); writeErr != nil && err == nil {
+			err = writeErr
+		}
+		packet = packet[:0]
+	}
+	all, skipped := i.series()
+	for _, s := range all {
+		for _, line := range e.lines(s) {
+			if len(packet) > 0 && len(packet)+1+len(line) > e.packetSize {
+				send()
+			}
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+			packet = append(packet, line...)
+		}
+	}
+	send()
+	if err != nil {
+		return err
+	}
+	return skipped
+}
+
+// Close closes the connection to the StatsD daemon.
+func (e *StatsDExporter) Close() error {
+	return e.conn.Close()
+}
+
+// lines returns the lines of the series. Lines that must be
+// received in order are joined into a single line to be sent
+// within the same packet.
+func (e *StatsDExporter) lines(s series) []string {
+	name := statsdName(s.path)
+	suffix := ""
+	if e.dogstatsd {
+		suffix = statsdTags(s.labels)
+	} else {
+		for _, l := range s.labels {
+			name += "." + statsdSegment(l.Name) + "." + statsdSegment(l.Value)
+		}
+	}
+	switch v := s.value.(type) {
+	case *Counter:
+		if !finite(v.Value) {
+			return nil
+		}
+		return []string{name + ":" + statsdFloat(v.Value) + "|c" + suffix}
+	case *Gauge:
+		if !finite(v.Value) {
+			return nil
+		}
+		return []string{statsdGauge(name, v.Value, suffix)}
+	case *Histogram:
+		typ := "|ms"
+		if e.dogstatsd {
+			typ = "|d"
+		}
+		var lines []string
+		sample := func(value float64, count uint64) {
+			if count == 0 || !finite(value) {
+				return
+			}
+			value = math.Max(math.Min(value, v.Max), v.Min)
+			line := name + ":" + statsdFloat(value) + typ
+			if count > 1 {
+				line += "|@" + strconv.FormatFloat(1/float64(count), 'g', -1, 64)
+			}
+			lines = append(lines, line+suffix)
+		}
+		for _, b := range v.Buckets {
+			sample(b.Value, b.Count)
+		}
+		sample(v.Max, v.Dropped)
+		return lines
+	}
+	p, ok := percentilesOf(s.value)
+	if !ok {
+		return nil
+	}
+	lines := []string{name + ".count:" + strconv.FormatUint(p.count, 10) + "|c" + suffix}
+	if p.count == 0 {
+		return lines
+	}
+	if finite(p.sum) {
+		lines = append(lines, name+".sum:"+statsdFloat(p.sum)+"|c"+suffix)
+	}
+	for _, q := range []struct {
+		suffix string
+		value  float64
+	}{{".p50", p.p50}, {".p99", p.p99}} {
+		if finite(q.value) {
+			lines = append(lines, statsdGauge(name+q.suffix, q.value, suffix))
+		}
+	}
+	return lines
+}
+
+// statsdGauge returns the gauge line setting name to value.
+func statsdGauge(name string, value float64, suffix string) string {
+	line := name + ":" + statsdFloat(value) + "|g" + suffix
+	if value < 0 {
+		// Signed values modify the gauge, so reset the gauge first.
+		line = name + ":0|g" + suffix + "\n" + line
+	}
+	return line
+}
+
+// statsdName returns the dotted StatsD name for the key path.
+func statsdName(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for n, s := range segments {
+		segments[n] = statsdSegment(s)
+	}
+	return strings.Join(segments, ".")
+}
+
+// statsdReplacer replaces the characters reserved by the
+// StatsD and DogStatsD line protocols with underscores.
+var statsdReplacer = strings.NewReplacer(
+	".", "_", ":", "_", "|", "_", "@", "_", "#", "_", ",", "_",
+	" ", "_", "\n", "_", "\r", "_", "\t", "_",
+)
+
+// statsdTagReplacer replaces the characters reserved
+// within DogStatsD tag values with underscores.
+var statsdTagReplacer = strings.NewReplacer(",", "_", "|", "_", "\n", "_", "\r", "_")
+
+// statsdSegment returns s with the reserved characters replaced.
+func statsdSegment(s string) string {
+	return statsdReplacer.Replace(s)
+}
+
+// statsdTags returns the labels as a DogStatsD tags
+// field, or the empty string if there are no labels.
+func statsdTags(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	tags := make([]string, len(labels))
+	for n, l := range labels {
+		tags[n] = l.Name + ":" + statsdTagReplacer.Replace(l.Value)
+	}
+	return "|#" + strings.Join(tags, ",")
+}
+
+// statsdFloat formats f without an exponent.
+func statsdFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// finite reports whether f is neither infinite nor NaN.
+func finite(f float64) bool {
+	return !math.IsInf(f, 0) && !math.IsNaN(f)
+}
@@ -0,0 +1,179 @@
+package metrics_test
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pnelson/metrics"
+)
+
+// listenStatsD returns a loopback UDP listener standing in for
+// a StatsD daemon and a function that reads n packets from it.
+func listenStatsD(t *testing.T) (net.PacketConn, func(n int) []string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, func(n int) []string {
+		t.Helper()
+		packets := make([]string, n)
+		buf := make([]byte, 65536)
+		for i := range packets {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			size, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			packets[i] = string(buf[:size])
+		}
+		return packets
+	}
+}
+
+// exportStatsD exports the completed interval of the
+// metrics recorded by fn to the StatsD exporter e.
+func exportStatsD(t *testing.T, e *metrics.StatsDExporter, fn func(m *metrics.Metrics)) {
+	t.Helper()
+	m, clock := newTestMetrics()
+	m.Export(e)
+	fn(m)
+	clock.Advance(testInterval)
+	m.Tick()
+}
+
+func TestStatsDExporter(t *testing.T) {
+	conn, read := listenStatsD(t)
+	e, err := metrics.NewStatsDExporter(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer e.Close()
+	exportStatsD(t, e, func(m *metrics.Metrics) {
+		m.Add([]string{"api", "requests"}, 3, metrics.Label{Name: "method", Value: "GET"})
+		m.Set([]string{"api", "active"}, -2)
+		m.Set([]string{"api", "v1.2"}, 7)
+		m.Buckets([]string{"api", "latency"}, []metrics.Bucket{{Value: 10}, {Value: 100}, {Value: 1000}})
+		m.Put([]string{"api", "latency"}, 5)
+		m.Put([]string{"api", "latency"}, 50)
+		m.Put([]string{"api", "latency"}, 60)
+		m.Put([]string{"api", "latency"}, 5000)
+		m.Record([]string{"api", "db"}, 100)
+	})
+	have := strings.Split(read(1)[0], "\n")
+	want := []string{
+		"api.active:0|g",
+		"api.active:-2|g",
+		"api.db.count:1|c",
+		"api.db.sum:100|c",
+		"api.db.p50:100|g",
+		"api.db.p99:100|g",
+		"api.latency:10|ms",
+		"api.latency:100|ms|@0.5",
+		"api.latency:5000|ms",
+		"api.requests.method.GET:3|c",
+		"api.v1_2:7|g",
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("lines\nhave %q\nwant %q", have, want)
+	}
+}
+
+func TestStatsDExporterDogStatsD(t *testing.T) {
+	conn, read := listenStatsD(t)
+	e, err := metrics.NewStatsDExporter(conn.LocalAddr().String(), metrics.WithDogStatsD())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer e.Close()
+	exportStatsD(t, e, func(m *metrics.Metrics) {
+		m.Add([]string{"api", "requests"}, 3,
+			metrics.Label{Name: "method", Value: "GET"},
+			metrics.Label{Name: "status", Value: "200"},
+		)
+		m.Buckets([]string{"api", "latency"}, []metrics.Bucket{{Value: 10}})
+		m.Put([]string{"api", "latency"}, 5, metrics.Label{Name: "method", Value: "GET"})
+	})
+	have := strings.Split(read(1)[0], "\n")
+	want := []string{
+		"api.latency:5|d|#method:GET",
+		"api.requests:3|c|#method:GET,status:200",
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("lines\nhave %q\nwant %q", have, want)
+	}
+}
+
+func TestStatsDExporterPacketSize(t *testing.T) {
+	conn, read := listenStatsD(t)
+	e, err := metrics.NewStatsDExporter(conn.LocalAddr().String(), metrics.WithStatsDPacketSize(64))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer e.Close()
+	var want []string
+	exportStatsD(t, e, func(m *metrics.Metrics) {
+		for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+			m.Add([]string{"counter", key}, 1)
+			want = append(want, "counter."+key+":1|c")
+		}
+	})
+	packets := read(3)
+	var have []string
+	for _, p := range packets {
+		if len(p) > 64 {
+			t.Fatalf("packet size\nhave %d\nwant at most %d", len(p), 64)
+		}
+		have = append(have, strings.Split(p, "\n")...)
+	}
+	sort.Strings(have)
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("lines\nhave %q\nwant %q", have, want)
+	}
+}
+
+func TestStatsDExporterServer(t *testing.T) {
+	dst, _ := newTestMetrics()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := metrics.NewStatsDServer(dst)
+	defer s.Close()
+	go s.Serve(conn)
+	e, err := metrics.NewStatsDExporter(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer e.Close()
+	exportStatsD(t, e, func(m *metrics.Metrics) {
+		m.Add([]string{"requests"}, 3)
+		m.Set([]string{"active"}, -2)
+		for v := 1; v <= 100; v++ {
+			m.Put([]string{"latency"}, float64(v))
+		}
+		m.Add([]string{"sentinel"}, 1)
+	})
+	waitFor(t, func() bool {
+		return dst.Window().Counter([]string{"sentinel"}).Count == 1
+	})
+	w := dst.Window()
+	if have := w.Counter([]string{"requests"}).Value; have != 3 {
+		t.Fatalf("Counter\nhave %f\nwant %f", have, 3.0)
+	}
+	if have := w.Gauge([]string{"active"}).Value; have != -2 {
+		t.Fatalf("Gauge\nhave %f\nwant %f", have, -2.0)
+	}
+	h, err := w.Histogram([]string{"latency"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.Count != 100 || h.Min != 1 || h.Max != 100 {
+		t.Fatalf("Histogram\nhave count %d, min %f and max %f\nwant count 100, min 1 and max 100", h.Count, h.Min, h.Max)
+	}
+}
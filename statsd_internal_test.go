@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatsDServerDropped(t *testing.T) {
+	m := New(time.Minute, time.Second, WithClock(NewManualClock(time.Now())))
+	s := NewStatsDServer(m)
+	packets := make(chan []byte, 1)
+	s.enqueue(packets, []byte("a:1|c"))
+	s.enqueue(packets, []byte("b:1|c"))
+	if len(packets) != 1 {
+		t.Fatalf("should queue the first packet")
+	}
+	have := m.Window().Counter([]string{"statsd", "dropped"}).Value
+	if have != 1 {
+		t.Fatalf("dropped\nhave %f\nwant %f", have, 1.0)
+	}
+}
+
+func TestStatsDServerSampleRate(t *testing.T) {
+	m := New(time.Minute, time.Second, WithClock(NewManualClock(time.Now())))
+	s := NewStatsDServer(m)
+	err := s.parseLine("latency:20|ms|@0.0000001")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := m.Window().Intervals[0].Histogram([]string{"latency"})
+	if h.Count != statsdSamples || h.Sum != 20*statsdSamples {
+		t.Fatalf("Histogram\nhave count %d and sum %f\nwant count %d and sum %d", h.Count, h.Sum, statsdSamples, 20*statsdSamples)
+	}
+}
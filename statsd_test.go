@@ -0,0 +1,142 @@
+package metrics_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pnelson/metrics"
+)
+
+// serveStatsD serves a StatsD server recording into m on a loopback
+// address and returns a connection to send packets to the server.
+func serveStatsD(t *testing.T, m *metrics.Metrics) (*metrics.StatsDServer, net.Conn, chan error) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := metrics.NewStatsDServer(m)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(conn)
+	}()
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		s.Close()
+	})
+	return s, client, served
+}
+
+// waitFor waits for the condition to be true.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStatsDServer(t *testing.T) {
+	m, _ := newTestMetrics()
+	_, client, _ := serveStatsD(t, m)
+	packets := []string{
+		"api.requests:1|c\napi.requests:2|c|@0.5",
+		"api.active:10|g\napi.active:+5|g\napi.active:-3|g",
+		"api.latency:20|ms\napi.latency:30|h|@0.25\napi.latency:40:50|d",
+		"api.requests:1|c|#status:500,env:prod\napi.requests:1|c|#canary",
+		"_e{5,4}:title|text\n_sc|check|0",
+		"done:1|c",
+	}
+	for _, p := range packets {
+		_, err := client.Write([]byte(p))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	waitFor(t, func() bool {
+		return m.Window().Counter([]string{"done"}).Count == 1
+	})
+	w := m.Window()
+	if have := w.Counter([]string{"api", "requests"}); have.Value != 5 || have.Count != 2 {
+		t.Fatalf("Counter\nhave %v\nwant value 5 and count 2", have)
+	}
+	if have := w.Counter([]string{"api", "requests", "env", "prod", "status", "500"}).Value; have != 1 {
+		t.Fatalf("Counter with tags\nhave %f\nwant %f", have, 1.0)
+	}
+	if have := w.Counter([]string{"api", "requests", "canary"}).Value; have != 1 {
+		t.Fatalf("Counter with tag name\nhave %f\nwant %f", have, 1.0)
+	}
+	g := w.Gauge([]string{"api", "active"})
+	if g.Value != 12 || g.Min != 10 || g.Max != 15 {
+		t.Fatalf("Gauge\nhave %v\nwant value 12, min 10 and max 15", g)
+	}
+	h, err := w.Histogram([]string{"api", "latency"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.Count != 7 || h.Sum != 20+4*30+40+50 {
+		t.Fatalf("Histogram\nhave count %d and sum %f\nwant count %d and sum %d", h.Count, h.Sum, 7, 20+4*30+40+50)
+	}
+	if have := w.Counter([]string{"statsd", "errors"}).Value; have != 0 {
+		t.Fatalf("errors\nhave %f\nwant %f", have, 0.0)
+	}
+}
+
+func TestStatsDServerErrors(t *testing.T) {
+	m, _ := newTestMetrics()
+	_, client, _ := serveStatsD(t, m)
+	lines := []string{
+		"missing",
+		"name:1",
+		"name:x|c",
+		"name:NaN|g",
+		"name:1|s",
+		"name:1|c|@0",
+		"name:1|c|@2",
+		"bad..name:1|c",
+		"name:1|c|#bad/tag",
+	}
+	for _, line := range lines {
+		_, err := client.Write([]byte(line))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	waitFor(t, func() bool {
+		return m.Window().Counter([]string{"statsd", "errors"}).Value == float64(len(lines))
+	})
+	w := m.Window()
+	if w.Counter([]string{"name"}).Count != 0 || w.Gauge([]string{"name"}).Count != 0 {
+		t.Fatalf("should not record invalid lines")
+	}
+}
+
+func TestStatsDServerClose(t *testing.T) {
+	m, _ := newTestMetrics()
+	s, _, served := serveStatsD(t, m)
+	err := s.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = <-served
+	if !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Serve\nhave %v\nwant %v", err, net.ErrClosed)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = s.Serve(conn)
+	if !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Serve after Close\nhave %v\nwant %v", err, net.ErrClosed)
+	}
+}
@@ -0,0 +1,238 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const kindSummary = ":summary"
+
+// DefaultCompression is the default compression of summaries.
+const DefaultCompression = 100
+
+// summaryBufferFactor is the number of samples buffered
+// per unit of compression before the samples are merged.
+const summaryBufferFactor = 5
+
+// Summary represents a distribution of samples as a t-digest, a
+// mergeable sketch of centroids that estimates percentiles without
+// configuring buckets. Percentiles near the tails are estimated
+// more accurately than the median.
+//
+// The compression bounds the number of centroids, trading memory
+// for accuracy. A digest holds at most about compression centroids
+// and the error of a percentile p is proportional to p(1-p) and
+// inversely proportional to the compression.
+type Summary struct {
+	Min         float64    `json:"min"`
+	Max         float64    `json:"max"`
+	Sum         float64    `json:"sum"`
+	Count       uint64     `json:"count"`
+	Compression float64    `json:"compression"`
+	Centroids   []Centroid `json:"centroids"`
+
+	buffer []Centroid // samples pending a merge into the centroids
+}
+
+// Centroid represents the mean of a cluster of samples.
+type Centroid struct {
+	Mean  float64
+	Count uint64
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (c Centroid) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("[%v,%d]", c.Mean, c.Count)), nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (c *Centroid) UnmarshalJSON(data []byte) error {
+	var v [2]json.Number
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	c.Mean, err = v[0].Float64()
+	if err != nil {
+		return err
+	}
+	c.Count, err = strconv.ParseUint(v[1].String(), 10, 64)
+	return err
+}
+
+// NewSummary returns a new summary with the compression.
+// DefaultCompression is used if compression is not positive.
+func NewSummary(compression float64) *Summary {
+	if !(compression > 0) {
+		compression = DefaultCompression
+	}
+	return &Summary{Compression: compression}
+}
+
+// Put adds value as a sample.
+func (m *Summary) Put(value float64) {
+	if value < m.Min || m.Count == 0 {
+		m.Min = value
+	}
+	if value > m.Max || m.Count == 0 {
+		m.Max = value
+	}
+	m.Sum += value
+	m.Count++
+	m.buffer = append(m.buffer, Centroid{Mean: value, Count: 1})
+	if float64(len(m.buffer)) >= m.compression()*summaryBufferFactor {
+		m.flush()
+	}
+}
+
+// Merge merges the samples of o into the summary. The compression
+// of o is adopted if the summary has no compression.
+func (m *Summary) Merge(o Summary) {
+	if m.Compression == 0 {
+		m.Compression = o.Compression
+	}
+	if o.Count == 0 {
+		return
+	}
+	if o.Min < m.Min || m.Count == 0 {
+		m.Min = o.Min
+	}
+	if o.Max > m.Max || m.Count == 0 {
+		m.Max = o.Max
+	}
+	m.Sum += o.Sum
+	m.Count += o.Count
+	m.buffer = append(m.buffer, o.Centroids...)
+	m.buffer = append(m.buffer, o.buffer...)
+	m.flush()
+}
+
+// Percentile returns the estimated value below which
+// a given percentage of the samples fall.
+func (m Summary) Percentile(p float64) float64 {
+	if m.Count == 0 {
+		return 0
+	}
+	if len(m.buffer) > 0 {
+		m.Centroids = append([]Centroid(nil), m.Centroids...)
+		m.flush()
+	}
+	c := m.Centroids
+	if p <= 0 || len(c) == 0 {
+		return m.Min
+	}
+	if p >= 1 {
+		return m.Max
+	}
+	total := 0.0
+	for _, v := range c {
+		total += float64(v.Count)
+	}
+	// Centroids are positioned at the midpoint of their samples
+	// and interpolated between the neighboring centroids, or the
+	// minimum and maximum beyond the first and last centroids.
+	rank := p * total
+	x0, y0 := m.Min, 0.0
+	cum := 0.0
+	for _, v := range c {
+		y1 := cum + float64(v.Count)/2
+		if rank < y1 {
+			return interpolate(x0, y0, v.Mean, y1, rank)
+		}
+		x0, y0 = v.Mean, y1
+		cum += float64(v.Count)
+	}
+	return interpolate(x0, y0, m.Max, total, rank)
+}
+
+// interpolate returns the value at y on the line
+// between the points (x0, y0) and (x1, y1).
+func interpolate(x0, y0, x1, y1, y float64) float64 {
+	if y1 <= y0 {
+		return x1
+	}
+	return x0 + (x1-x0)*(y-y0)/(y1-y0)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+// Samples pending a merge are merged into the centroids.
+func (m Summary) MarshalJSON() ([]byte, error) {
+	if len(m.buffer) > 0 {
+		m.Centroids = append([]Centroid(nil), m.Centroids...)
+		m.flush()
+	}
+	type summary Summary
+	return json.Marshal(summary(m))
+}
+
+// compression returns the compression of the summary,
+// or DefaultCompression if the summary has no compression.
+func (m *Summary) compression() float64 {
+	if !(m.Compression > 0) {
+		return DefaultCompression
+	}
+	return m.Compression
+}
+
+// flush merges the buffered samples into the centroids. Adjacent
+// centroids are merged while the merged centroid remains within
+// the size limit of the k1 scale function at its quantile, which
+// limits centroids towards the tails to fewer samples.
+func (m *Summary) flush() {
+	if len(m.buffer) == 0 {
+		return
+	}
+	c := append(m.Centroids, m.buffer...)
+	m.buffer = nil
+	sort.Slice(c, func(a, b int) bool {
+		return c[a].Mean < c[b].Mean
+	})
+	total := 0.0
+	for _, v := range c {
+		total += float64(v.Count)
+	}
+	delta := m.compression()
+	merged := c[:1]
+	sofar := 0.0
+	limit := total * summaryQ(summaryK(0, delta)+1, delta)
+	for _, v := range c[1:] {
+		cur := &merged[len(merged)-1]
+		if sofar+float64(cur.Count+v.Count) <= limit {
+			n := cur.Count + v.Count
+			cur.Mean += (v.Mean - cur.Mean) * float64(v.Count) / float64(n)
+			cur.Count = n
+			continue
+		}
+		sofar += float64(cur.Count)
+		limit = total * summaryQ(summaryK(sofar/total, delta)+1, delta)
+		merged = append(merged, v)
+	}
+	m.Centroids = merged
+}
+
+// summaryK returns the k1 scale function at quantile q.
+func summaryK(q, delta float64) float64 {
+	return delta / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// summaryQ returns the quantile at k of the k1 scale function.
+func summaryQ(k, delta float64) float64 {
+	if k >= delta/4 {
+		return 1
+	}
+	return (math.Sin(k*2*math.Pi/delta) + 1) / 2
+}
+
+// copySummary returns a deep copy of the summary
+// with the buffered samples merged.
+func copySummary(s *Summary) *Summary {
+	c := new(Summary)
+	*c = *s
+	c.Centroids = append([]Centroid(nil), s.Centroids...)
+	c.buffer = append([]Centroid(nil), s.buffer...)
+	c.flush()
+	return c
+}
@@ -0,0 +1,137 @@
+package metrics_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/pnelson/metrics"
+)
+
+func TestSummaryPercentile(t *testing.T) {
+	m := metrics.NewSummary(metrics.DefaultCompression)
+	r := rand.New(rand.NewSource(1))
+	for _, v := range r.Perm(100000) {
+		m.Put(float64(v + 1))
+	}
+	if m.Count != 100000 || m.Min != 1 || m.Max != 100000 || m.Sum != 100000*100001/2 {
+		t.Fatalf("Summary\nhave count %d, min %f, max %f and sum %f", m.Count, m.Min, m.Max, m.Sum)
+	}
+	tests := []struct {
+		p         float64
+		want      float64
+		tolerance float64 // of the rank
+	}{
+		{0, 1, 0},
+		{0.001, 100, 0.0005},
+		{0.5, 50000, 0.002},
+		{0.9, 90000, 0.001},
+		{0.99, 99000, 0.0005},
+		{0.999, 99900, 0.0005},
+		{1, 100000, 0},
+	}
+	for _, tt := range tests {
+		have := m.Percentile(tt.p)
+		if math.Abs(have-tt.want) > tt.tolerance*100000 {
+			t.Fatalf("Percentile(%v)\nhave %f\nwant %f", tt.p, have, tt.want)
+		}
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var decoded metrics.Summary
+	err = json.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(decoded.Centroids) > metrics.DefaultCompression {
+		t.Fatalf("centroids\nhave %d\nwant at most %d", len(decoded.Centroids), metrics.DefaultCompression)
+	}
+	if have, want := decoded.Percentile(0.99), m.Percentile(0.99); have != want {
+		t.Fatalf("Percentile after JSON\nhave %f\nwant %f", have, want)
+	}
+}
+
+func TestSummaryMerge(t *testing.T) {
+	var a, b metrics.Summary
+	all := metrics.NewSummary(metrics.DefaultCompression)
+	for v := 1; v <= 10000; v++ {
+		if v%3 == 0 {
+			a.Put(float64(v))
+		} else {
+			b.Put(float64(v))
+		}
+		all.Put(float64(v))
+	}
+	a.Merge(b)
+	if a.Count != all.Count || a.Min != all.Min || a.Max != all.Max || a.Sum != all.Sum {
+		t.Fatalf("Merge\nhave %v\nwant %v", a, all)
+	}
+	for _, p := range []float64{0.1, 0.5, 0.9, 0.99} {
+		have, want := a.Percentile(p), all.Percentile(p)
+		if math.Abs(have-want) > 0.002*10000 {
+			t.Fatalf("Percentile(%v)\nhave %f\nwant %f", p, have, want)
+		}
+	}
+}
+
+func TestMetricsObserve(t *testing.T) {
+	m, clock := newTestMetrics()
+	m.Compression([]string{"db"}, 50)
+	for n := 0; n < 3; n++ {
+		for v := 1; v <= 100; v++ {
+			m.Observe([]string{"db", "latency"}, float64(n*100+v))
+		}
+		clock.Advance(testInterval)
+		m.Tick()
+	}
+	w := m.Window()
+	i := &w.Intervals[0]
+	if have := i.Summary([]string{"db", "latency"}); have.Count != 100 || have.Compression != 50 {
+		t.Fatalf("Interval.Summary\nhave count %d and compression %f\nwant count 100 and compression 50", have.Count, have.Compression)
+	}
+	s := w.Summary([]string{"db", "latency"})
+	if s.Count != 300 || s.Min != 1 || s.Max != 300 {
+		t.Fatalf("Window.Summary\nhave count %d, min %f and max %f\nwant count 300, min 1 and max 300", s.Count, s.Min, s.Max)
+	}
+	if have := s.Percentile(0.5); math.Abs(have-150) > 3 {
+		t.Fatalf("Percentile\nhave %f\nwant %f", have, 150.0)
+	}
+	b, err := json.Marshal(w)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var decoded metrics.Window
+	err = json.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if have, want := decoded.Summary([]string{"db", "latency"}).Percentile(0.9), s.Percentile(0.9); have != want {
+		t.Fatalf("Percentile after JSON\nhave %f\nwant %f", have, want)
+	}
+}
+
+func TestMetricsWritePrometheusSummary(t *testing.T) {
+	m, _ := newTestMetrics()
+	for v := 1; v <= 4; v++ {
+		m.Observe([]string{"db", "latency"}, float64(v))
+	}
+	var b bytes.Buffer
+	err := m.WritePrometheus(&b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `# TYPE db_latency summary
+db_latency{quantile="0.5"} 2.5
+db_latency{quantile="0.9"} 4
+db_latency{quantile="0.99"} 4
+db_latency_sum 10
+db_latency_count 4
+`
+	if have := b.String(); have != want {
+		t.Fatalf("prometheus\nhave %s\nwant %s", have, want)
+	}
+}
@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tableColumns are the columns of each row of a table
+// preceding the bucket columns of a wide table.
+var tableColumns = []string{"time", "key", "kind", "min", "max", "value", "sum", "count", "dropped"}
+
+// table represents the configuration of tabular output.
+type table struct {
+	wide bool
+}
+
+// TableOption represents a functional option for
+// configuring tabular output.
+type TableOption func(*table)
+
+// WithWideTable expands histograms into a bucket_<value> column
+// with the count of each bucket value of the histograms in the
+// window. Histograms without the bucket value leave the column empty.
+func WithWideTable() TableOption {
+	return func(t *table) {
+		t.wide = true
+	}
+}
+
+// WriteCSV writes the window to dst as comma separated values with
+// a header row followed by a row for each metric of each interval.
+// The columns are the interval time in RFC 3339 format, the key
+// with the labels, the metric kind and the min, max, value, sum,
+// count and dropped fields. Fields that the kind of metric does not
+// have are left empty.
+//
+// Rows are written as the intervals are read such that the table
+// is not held in memory. Keys that cannot be parsed are skipped and
+// a *KeyError is returned once the remaining rows are written.
+func (w Window) WriteCSV(dst io.Writer, opts ...TableOption) error {
+	return w.writeTable(dst, ',', opts)
+}
+
+// WriteTSV writes the window to dst as tab separated values
+// with the rows and columns written by WriteCSV.
+func (w Window) WriteTSV(dst io.Writer, opts ...TableOption) error {
+	return w.writeTable(dst, '\t', opts)
+}
+
+// writeTable writes the window to dst as values separated by comma.
+func (w Window) writeTable(dst io.Writer, comma rune, opts []TableOption) error {
+	t := &table{}
+	for _, option := range opts {
+		option(t)
+	}
+	var buckets []float64
+	if t.wide {
+		buckets = w.bucketValues()
+	}
+	cw := csv.NewWriter(dst)
+	cw.Comma = comma
+	header := append([]string(nil), tableColumns...)
+	for _, v := range buckets {
+		header = append(header, "bucket_"+formatTableFloat(v))
+	}
+	err := cw.Write(header)
+	if err != nil {
+		return err
+	}
+	row := make([]string, len(header))
+	var skipped error
+	for n := range w.Intervals {
+		i := &w.Intervals[n]
+		ts := i.time.UTC().Format(time.RFC3339Nano)
+		all, err := i.series()
+		if err != nil && skipped == nil {
+			skipped = err
+		}
+		for _, s := range all {
+			for c := range row {
+				row[c] = ""
+			}
+			path, _ := splitKind(s.key)
+			row[0] = ts
+			row[1] = path
+			row[2] = strings.TrimPrefix(s.kind, ":")
+			switch v := s.value.(type) {
+			case *Counter:
+				row[3] = formatTableFloat(v.Min)
+				row[4] = formatTableFloat(v.Max)
+				row[5] = formatTableFloat(v.Value)
+				row[7] = strconv.FormatUint(v.Count, 10)
+			case *Gauge:
+				row[3] = formatTableFloat(v.Min)
+				row[4] = formatTableFloat(v.Max)
+				row[5] = formatTableFloat(v.Value)
+				row[7] = strconv.FormatUint(v.Count, 10)
+			case *Histogram:
+				row[3] = formatTableFloat(v.Min)
+				row[4] = formatTableFloat(v.Max)
+				row[6] = formatTableFloat(v.Sum)
+				row[7] = strconv.FormatUint(v.Count, 10)
+				row[8] = strconv.FormatUint(v.Dropped, 10)
+				for _, b := range v.Buckets {
+					c := sort.SearchFloat64s(buckets, b.Value)
+					if c < len(buckets) && buckets[c] == b.Value {
+						row[len(tableColumns)+c] = strconv.FormatUint(b.Count, 10)
+					}
+				}
+			case *Summary:
+				row[3] = formatTableFloat(v.Min)
+				row[4] = formatTableFloat(v.Max)
+				row[6] = formatTableFloat(v.Sum)
+				row[7] = strconv.FormatUint(v.Count, 10)
+			case *Sketch:
+				row[3] = formatTableFloat(v.Min)
+				row[4] = formatTableFloat(v.Max)
+				row[6] = formatTableFloat(v.Sum)
+				row[7] = strconv.FormatUint(v.Count, 10)
+			case *HDRHistogram:
+				row[3] = strconv.FormatInt(v.Min, 10)
+				row[4] = strconv.FormatInt(v.Max, 10)
+				row[6] = formatTableFloat(v.Sum)
+				row[7] = strconv.FormatUint(v.Count, 10)
+			}
+			err = cw.Write(row)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	err = cw.Error()
+	if err != nil {
+		return err
+	}
+	return skipped
+}
+
+// bucketValues returns the sorted set of the
+// bucket values of the histograms in the window.
+func (w Window) bucketValues() []float64 {
+	seen := make(map[float64]struct{})
+	var values []float64
+	for n := range w.Intervals {
+		for _, v := range w.Intervals[n].metrics {
+			h, ok := v.(*Histogram)
+			if !ok {
+				continue
+			}
+			for _, b := range h.Buckets {
+				if _, ok := seen[b.Value]; !ok {
+					seen[b.Value] = struct{}{}
+					values = append(values, b.Value)
+				}
+			}
+		}
+	}
+	sort.Float64s(values)
+	return values
+}
+
+// formatTableFloat formats f in the shortest representation.
+func formatTableFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
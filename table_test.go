@@ -0,0 +1,63 @@
+package metrics_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/pnelson/metrics"
+)
+
+// tableWindow is a window of two intervals with histograms
+// of different buckets for testing tabular output.
+const tableWindow = `{"duration":60,"intervals":[
+	{"time":1640995200000,"metrics":{
+		"/http/requests{method=\"GET\"}:counter":{"min":1,"max":2,"value":3,"count":2},
+		"/http/latency:histogram":{"min":5,"max":50,"sum":65,"count":3,"dropped":1,"buckets":[[10,1],[20,1]]}}},
+	{"time":1640995260000,"metrics":{
+		"/http/active:gauge":{"min":-1,"max":4,"value":2.5,"count":3},
+		"/http/latency:histogram":{"min":1,"max":1,"sum":1,"count":1,"dropped":0,"buckets":[[1,1],[10,0]]}}}
+]}`
+
+func TestWindowWriteCSV(t *testing.T) {
+	var w metrics.Window
+	err := json.Unmarshal([]byte(tableWindow), &w)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	err = w.WriteCSV(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `time,key,kind,min,max,value,sum,count,dropped
+2022-01-01T00:00:00Z,/http/latency,histogram,5,50,,65,3,1
+2022-01-01T00:00:00Z,"/http/requests{method=""GET""}",counter,1,2,3,,2,
+2022-01-01T00:01:00Z,/http/active,gauge,-1,4,2.5,,3,
+2022-01-01T00:01:00Z,/http/latency,histogram,1,1,,1,1,0
+`
+	if have := buf.String(); have != want {
+		t.Fatalf("WriteCSV\nhave %s\nwant %s", have, want)
+	}
+}
+
+func TestWindowWriteTSVWide(t *testing.T) {
+	var w metrics.Window
+	err := json.Unmarshal([]byte(tableWindow), &w)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	err = w.WriteTSV(&buf, metrics.WithWideTable())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "time\tkey\tkind\tmin\tmax\tvalue\tsum\tcount\tdropped\tbucket_1\tbucket_10\tbucket_20\n" +
+		"2022-01-01T00:00:00Z\t/http/latency\thistogram\t5\t50\t\t65\t3\t1\t\t1\t1\n" +
+		"2022-01-01T00:00:00Z\t\"/http/requests{method=\"\"GET\"\"}\"\tcounter\t1\t2\t3\t\t2\t\t\t\t\n" +
+		"2022-01-01T00:01:00Z\t/http/active\tgauge\t-1\t4\t2.5\t\t3\t\t\t\t\n" +
+		"2022-01-01T00:01:00Z\t/http/latency\thistogram\t1\t1\t\t1\t1\t0\t1\t0\t\n"
+	if have := buf.String(); have != want {
+		t.Fatalf("WriteTSV\nhave %q\nwant %q", have, want)
+	}
+}
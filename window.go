@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+)
+
+// Range returns the window limited to the intervals with times
+// between since and until inclusive. A zero since or until leaves
+// the range unbounded in that direction.
+func (w Window) Range(since, until time.Time) Window {
+	view := Window{Duration: w.Duration}
+	for n := range w.Intervals {
+		t := w.Intervals[n].time
+		if !since.IsZero() && t.Before(since) {
+			continue
+		}
+		if !until.IsZero() && t.After(until) {
+			continue
+		}
+		view.Intervals = append(view.Intervals, Interval{time: t, metrics: w.Intervals[n].metrics})
+	}
+	return view
+}
+
+// Counter returns the sum of the counters at key
+// with the labels across the intervals of the window.
+func (w Window) Counter(key []string, labels ...Label) Counter {
+	m := Counter{}
+	if ValidateKey(key, labels...) != nil {
+		return m
+	}
+	k := canonicalKey(key, labels, kindCounter)
+	for n := range w.Intervals {
+		if v, ok := w.Intervals[n].metrics[k]; ok {
+			m.Merge(storedCounter(v))
+		}
+	}
+	return m
+}
+
+// Gauge returns the minimum, maximum and last values of the gauges
+// at key with the labels across the intervals of the window.
+func (w Window) Gauge(key []string, labels ...Label) Gauge {
+	m := Gauge{}
+	if ValidateKey(key, labels...) != nil {
+		return m
+	}
+	k := canonicalKey(key, labels, kindGauge)
+	for n := range w.Intervals {
+		if v, ok := w.Intervals[n].metrics[k]; ok {
+			m.Merge(storedGauge(v))
+		}
+	}
+	return m
+}
+
+// Histogram returns the merged histograms at key with the labels
+// across the intervals of the window. An error wrapping
+// ErrBucketMismatch is returned if the bucket values differ
+// between intervals.
+func (w Window) Histogram(key []string, labels ...Label) (Histogram, error) {
+	m := Histogram{}
+	for n := range w.Intervals {
+		i := &w.Intervals[n]
+		err := m.Merge(i.Histogram(key, labels...))
+		if err != nil {
+			return Histogram{}, fmt.Errorf("%w at %v", err, i.time)
+		}
+	}
+	return m, nil
+}
+
+// Summary returns the merged summaries at key with
+// the labels across the intervals of the window.
+func (w Window) Summary(key []string, labels ...Label) Summary {
+	m := Summary{}
+	for n := range w.Intervals {
+		m.Merge(w.Intervals[n].Summary(key, labels...))
+	}
+	return m
+}
+
+// Sketch returns the merged sketches at key with the labels
+// across the intervals of the window. An error wrapping
+// ErrAccuracyMismatch is returned if the accuracy differs
+// between intervals.
+func (w Window) Sketch(key []string, labels ...Label) (Sketch, error) {
+	m := Sketch{}
+	for n := range w.Intervals {
+		i := &w.Intervals[n]
+		err := m.Merge(i.Sketch(key, labels...))
+		if err != nil {
+			return Sketch{}, fmt.Errorf("%w at %v", err, i.time)
+		}
+	}
+	return m, nil
+}
+
+// HDRHistogram returns the merged HDR histograms at key with the
+// labels across the intervals of the window. An error wrapping
+// ErrDigitsMismatch is returned if the significant digits differ
+// between intervals.
+func (w Window) HDRHistogram(key []string, labels ...Label) (HDRHistogram, error) {
+	m := HDRHistogram{}
+	for n := range w.Intervals {
+		i := &w.Intervals[n]
+		err := m.Merge(i.HDRHistogram(key, labels...))
+		if err != nil {
+			return HDRHistogram{}, fmt.Errorf("%w at %v", err, i.time)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,88 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/pnelson/metrics"
+)
+
+func TestWindowQuery(t *testing.T) {
+	m, clock := newTestMetrics()
+	m.Buckets([]string{"h"}, metrics.NewLinearBuckets(10, 10, 3))
+	for n, v := range []float64{4, 2, 8, 6} {
+		if n > 0 {
+			clock.Advance(testInterval)
+			m.Tick()
+		}
+		m.Add([]string{"c"}, v)
+		m.Set([]string{"g"}, v)
+		m.Put([]string{"h"}, v*5)
+	}
+	w := m.Window()
+	c := w.Counter([]string{"c"})
+	if c.Value != 20 || c.Count != 4 || c.Min != 2 || c.Max != 8 {
+		t.Fatalf("Counter\nhave %+v", c)
+	}
+	g := w.Gauge([]string{"g"})
+	if g.Value != 6 || g.Count != 4 || g.Min != 2 || g.Max != 8 {
+		t.Fatalf("Gauge\nhave %+v", g)
+	}
+	h, err := w.Histogram([]string{"h"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.Count != 4 || h.Min != 10 || h.Max != 40 || h.Sum != 100 || h.Dropped != 1 {
+		t.Fatalf("Histogram\nhave %+v", h)
+	}
+	if p := h.Percentile(0.5); p != 20 {
+		t.Fatalf("Percentile\nhave %f\nwant %f", p, 20.0)
+	}
+	r := w.Range(w.Intervals[1].Time(), w.Intervals[2].Time())
+	if len(r.Intervals) != 2 {
+		t.Fatalf("Range\nhave %d intervals\nwant %d", len(r.Intervals), 2)
+	}
+	if c := r.Counter([]string{"c"}); c.Value != 10 {
+		t.Fatalf("Range Counter\nhave %f\nwant %f", c.Value, 10.0)
+	}
+	if g := r.Gauge([]string{"g"}); g.Value != 8 || g.Min != 2 {
+		t.Fatalf("Range Gauge\nhave %+v", g)
+	}
+	if c := w.Counter([]string{"missing"}); c.Count != 0 {
+		t.Fatalf("should return a zero counter")
+	}
+}
+
+func TestWindowHistogramMismatch(t *testing.T) {
+	m, clock := newTestMetrics()
+	m.Put([]string{"h"}, 1)
+	clock.Advance(testInterval)
+	m.Tick()
+	m.Buckets([]string{"h"}, metrics.NewLinearBuckets(10, 10, 3))
+	m.Put([]string{"h"}, 1)
+	_, err := m.Window().Histogram([]string{"h"})
+	if !errors.Is(err, metrics.ErrBucketMismatch) {
+		t.Fatalf("should error on mismatched buckets\nhave %v", err)
+	}
+}
+
+func TestWindowGaugeZeroCount(t *testing.T) {
+	var w metrics.Window
+	err := json.Unmarshal([]byte(`{"duration":2,"intervals":[
+		{"time":1,"metrics":{"/g:gauge":{"min":5,"max":5,"value":5,"count":1}}},
+		{"time":2,"metrics":{"/g:gauge":{"min":0,"max":0,"value":0,"count":0}}}
+	]}`), &w)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := metrics.Gauge{Min: 0, Max: 5, Value: 0, Count: 2}
+	if g := w.Gauge([]string{"g"}); g != want {
+		t.Fatalf("Gauge\nhave %+v\nwant %+v", g, want)
+	}
+	c := metrics.Counter{}
+	c.Merge(*metrics.NewCounter(0))
+	if c.Count != 0 {
+		t.Fatalf("should not merge a zero count\nhave %d\nwant 0", c.Count)
+	}
+}